@@ -5,4 +5,5 @@ go 1.19
 require (
 	github.com/esiqveland/notify v0.11.2
 	github.com/godbus/dbus/v5 v5.1.0
+	gopkg.in/yaml.v3 v3.0.1
 )
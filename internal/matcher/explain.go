@@ -0,0 +1,144 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package matcher
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Mismatch tells where a route departs from a template.
+type Mismatch struct {
+	// Shape is set when the route has the separators of the template, in
+	// the same order, so that only the values of its variables may be
+	// invalid.
+	Shape bool
+	// Var is the first variable whose value is invalid, if Shape is set.
+	// Otherwise Literal is the separator the route lacks, empty if the
+	// route goes on past the end of the template.
+	Var     string
+	Literal string
+	// Got is the value of Var, or the rest of the route where Literal was
+	// expected.
+	Got string
+	// Invalid is the number of variables with an invalid value, if Shape
+	// is set, and Matched the number of parts of the template the route
+	// matched.
+	Invalid int
+	Matched int
+}
+
+// OK reports whether the route matches the template.
+func (m Mismatch) OK() bool {
+	return m.Shape && m.Invalid == 0
+}
+
+// part is a literal or a variable of a template.
+type part struct {
+	lit string
+	id  string
+}
+
+// parts splits a well formed template into literals and variables.
+func parts(tmpl string) []part {
+	var ps []part
+	for tmpl != "" {
+		i := strings.IndexByte(tmpl, '{')
+		if i < 0 {
+			ps = append(ps, part{lit: tmpl})
+			break
+		}
+		if i > 0 {
+			ps = append(ps, part{lit: tmpl[:i]})
+		}
+		j := strings.IndexByte(tmpl, '}')
+		ps = append(ps, part{id: tmpl[i+1 : j]})
+		tmpl = tmpl[j+1:]
+	}
+	return ps
+}
+
+// looseVar matches the value of any variable, up to the next separator.
+const looseVar = `([^:@/?#]*)`
+
+// Explain compares the route to the template. Unlike IsMatch it checks the
+// whole route up to its path: the route only matches if nothing follows
+// the template but a path, a query or a fragment. Values holding a ${...}
+// placeholder are not checked.
+func (m *Matcher) Explain(route string) Mismatch {
+	ps := parts(m.tmpl)
+
+	// a template without variables after the scheme takes any route.
+	tail := `(?:[/?#].*)?`
+	if last := ps[len(ps)-1]; last.id == "" && strings.HasSuffix(last.lit, "://") {
+		tail = `.*`
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+	for _, p := range ps {
+		if p.id != "" {
+			b.WriteString(looseVar)
+		} else {
+			b.WriteString(regexp.QuoteMeta(p.lit))
+		}
+	}
+	if match := regexp.MustCompile(b.String() + tail + "$").FindStringSubmatch(route); match != nil {
+		return explainVars(ps, match[1:])
+	}
+
+	// find the first separator of the template the route lacks.
+	mm := Mismatch{Matched: len(ps)}
+	b.Reset()
+	b.WriteString("^")
+	for i, p := range ps {
+		prefix := b.String()
+		if p.id != "" {
+			b.WriteString(looseVar)
+			continue
+		}
+		b.WriteString(regexp.QuoteMeta(p.lit))
+		if !regexp.MustCompile(b.String()).MatchString(route) {
+			mm.Literal, mm.Matched = p.lit, i
+			mm.Got = route[len(regexp.MustCompile(prefix).FindString(route)):]
+			return mm
+		}
+	}
+
+	// the route goes on past the template.
+	mm.Got = route[len(regexp.MustCompile(b.String()).FindString(route)):]
+	return mm
+}
+
+// explainVars checks the values of the variables of a route matching the
+// shape of the template.
+func explainVars(ps []part, values []string) Mismatch {
+	mm := Mismatch{Shape: true, Matched: len(ps)}
+	i := 0
+	for _, p := range ps {
+		if p.id == "" {
+			continue
+		}
+		v := values[i]
+		i++
+		if strings.Contains(v, "${") {
+			continue
+		}
+		if !regexp.MustCompile(`^(?:` + varRegex(p.id) + `)$`).MatchString(v) {
+			if mm.Invalid == 0 {
+				mm.Var, mm.Got = p.id, v
+			}
+			mm.Invalid++
+		}
+	}
+	return mm
+}
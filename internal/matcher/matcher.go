@@ -19,18 +19,29 @@ import (
 )
 
 type Matcher struct {
+	tmpl   string
 	regex  *regexp.Regexp
 	scheme string
 	idents []string
 	vars   map[string]string
 }
 
-// NewMatcher takes a route string and returns a Matcher.
+// NewMatcher takes a route string and returns a Matcher. It panics if
+// the template is malformed.
 func New(tmpl string) *Matcher {
-	regex, err := newTmplRegex(tmpl)
+	m, err := Compile(tmpl)
 	if err != nil {
 		panic(err)
 	}
+	return m
+}
+
+// Compile is like New but returns an error if the template is malformed.
+func Compile(tmpl string) (*Matcher, error) {
+	regex, err := newTmplRegex(tmpl)
+	if err != nil {
+		return nil, err
+	}
 
 	var idents []string
 	// loop through the subexpressions and add them to the idents if they
@@ -43,7 +54,17 @@ func New(tmpl string) *Matcher {
 
 	// extract the scheme from the template
 	scheme := strings.Split(tmpl, "://")[0]
-	return &Matcher{regex: regex, scheme: scheme, idents: idents}
+	return &Matcher{tmpl: tmpl, regex: regex, scheme: scheme, idents: idents}, nil
+}
+
+// Template returns the template of the matcher.
+func (m *Matcher) Template() string {
+	return m.tmpl
+}
+
+// Scheme returns the scheme of the template, e.g. "json".
+func (m *Matcher) Scheme() string {
+	return m.scheme
 }
 
 // IsMatch takes a route string and checks if it matches the
@@ -80,8 +101,56 @@ func (m *Matcher) Vars(route string) (map[string]string, error) {
 	return vars, nil
 }
 
+// StripQuery removes the given keys from the query of the route. The route
+// is returned unchanged if none of the keys are present.
+func StripQuery(route string, keys ...string) string {
+	i := strings.IndexByte(route, '?')
+	if i < 0 {
+		return route
+	}
+
+	rest, fragment := route[i+1:], ""
+	if j := strings.IndexByte(rest, '#'); j >= 0 {
+		rest, fragment = rest[:j], rest[j:]
+	}
+
+	q, err := url.ParseQuery(rest)
+	if err != nil {
+		return route
+	}
+
+	var stripped bool
+	for _, k := range keys {
+		if _, ok := q[k]; ok {
+			q.Del(k)
+			stripped = true
+		}
+	}
+	if !stripped {
+		return route
+	}
+
+	if len(q) == 0 {
+		return route[:i] + fragment
+	}
+	return route[:i+1] + q.Encode() + fragment
+}
+
 // regex string for valid hostname including ip4 and ip6 addresses
-var hostRegex = `(([a-zA-Z]|[a-zA-Z][a-zA-Z0-9\-]*[a-zA-Z0-9])\.)*([A-Za-z]|[A-Za-z][A-Za-z0-9\-]*[A-Za-z0-9]))`
+// NOTE: the longer alternatives come first since the leftmost alternative
+// wins, which would otherwise cut a trailing host to its first letter.
+var hostRegex = `(([a-zA-Z][a-zA-Z0-9\-]*[a-zA-Z0-9]|[a-zA-Z])\.)*([A-Za-z][A-Za-z0-9\-]*[A-Za-z0-9]|[A-Za-z])`
+
+// varRegex returns the regex of the values of the template variable.
+func varRegex(id string) string {
+	switch id {
+	case "host":
+		return hostRegex
+	case "port":
+		return `[0-9]+`
+	}
+	return `[\w]+`
+}
 
 // newTmplRegex takes a template string and returns a regexp.Regexp
 // used to match the route to a service template.
@@ -111,11 +180,8 @@ func newTmplRegex(tmpl string) (*regexp.Regexp, error) {
 			id := tmpl[start+1 : end]
 			b.WriteString(`(?P`)
 			b.WriteString("<" + id + ">")
-			if id == "host" {
-				b.WriteString(hostRegex)
-			} else {
-				b.WriteString(`[\w]+)`)
-			}
+			b.WriteString(varRegex(id))
+			b.WriteString(")")
 
 			// add the length of the brace to the current index
 			i += end - start
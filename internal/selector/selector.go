@@ -0,0 +1,185 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package selector
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Selector is a boolean expression over tags, e.g. "oncall && !desktop".
+// Tags are combined with && (and), || (or), ! (not) and parentheses, &&
+// binding tighter than ||.
+type Selector struct {
+	expr string
+	root node
+}
+
+// Parse parses a tag expression into a Selector.
+func Parse(expr string) (*Selector, error) {
+	p := &parser{tokens: tokenize(expr)}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("empty tag expression")
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid tag expression %q: %w", expr, err)
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("invalid tag expression %q: unexpected %q", expr, p.peek())
+	}
+	return &Selector{expr: expr, root: root}, nil
+}
+
+// Match reports whether the tags satisfy the expression.
+func (s *Selector) Match(tags []string) bool {
+	set := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		set[t] = true
+	}
+	return s.root.eval(set)
+}
+
+// String returns the expression the Selector was parsed from.
+func (s *Selector) String() string {
+	return s.expr
+}
+
+// node is a node of the expression tree.
+type node interface {
+	eval(tags map[string]bool) bool
+}
+
+type tagNode string
+
+func (n tagNode) eval(tags map[string]bool) bool { return tags[string(n)] }
+
+type notNode struct{ x node }
+
+func (n notNode) eval(tags map[string]bool) bool { return !n.x.eval(tags) }
+
+type andNode struct{ x, y node }
+
+func (n andNode) eval(tags map[string]bool) bool { return n.x.eval(tags) && n.y.eval(tags) }
+
+type orNode struct{ x, y node }
+
+func (n orNode) eval(tags map[string]bool) bool { return n.x.eval(tags) || n.y.eval(tags) }
+
+// tokenize splits the expression into operators, parentheses and tags.
+func tokenize(expr string) []string {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case strings.HasPrefix(expr[i:], "&&"), strings.HasPrefix(expr[i:], "||"):
+			tokens = append(tokens, expr[i:i+2])
+			i += 2
+		case c == '!' || c == '(' || c == ')':
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			j := i
+			for j < len(expr) && !strings.ContainsRune(" \t!()&|", rune(expr[j])) {
+				j++
+			}
+			if j == i {
+				// a lone & or |.
+				j++
+			}
+			tokens = append(tokens, expr[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+type parser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *parser) done() bool { return p.pos >= len(p.tokens) }
+
+func (p *parser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *parser) parseOr() (node, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.next()
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		x = orNode{x, y}
+	}
+	return x, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.next()
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		x = andNode{x, y}
+	}
+	return x, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	switch t := p.next(); t {
+	case "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case "!":
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{x}, nil
+	case "(":
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		return x, nil
+	case ")", "&&", "||", "&", "|":
+		return nil, fmt.Errorf("unexpected %q", t)
+	default:
+		return tagNode(t), nil
+	}
+}
@@ -0,0 +1,54 @@
+package selector
+
+import "testing"
+
+// TestMatch tests the evaluation of tag expressions.
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		expr  string
+		tags  []string
+		match bool
+	}{
+		{"oncall", []string{"oncall"}, true},
+		{"oncall", []string{"db"}, false},
+		{"oncall && !desktop", []string{"oncall"}, true},
+		{"oncall && !desktop", []string{"oncall", "desktop"}, false},
+		{"db || deploy", []string{"deploy"}, true},
+		{"db || deploy && prod", []string{"db"}, true},
+		{"(db || deploy) && prod", []string{"db"}, false},
+		{"(db || deploy) && prod", []string{"deploy", "prod"}, true},
+		{"!!db", []string{"db"}, true},
+		{"scheme:gnome", []string{"scheme:gnome"}, true},
+	}
+
+	for _, test := range tests {
+		s, err := Parse(test.expr)
+		if err != nil {
+			t.Errorf("expected no error for %q, got %v", test.expr, err)
+			continue
+		}
+		if s.Match(test.tags) != test.match {
+			t.Errorf("expected %q to match %v: %t", test.expr, test.tags, test.match)
+		}
+	}
+}
+
+// TestParseInvalid tests that malformed expressions are rejected.
+func TestParseInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"db &&",
+		"&& db",
+		"db & prod",
+		"(db || prod",
+		"db || prod)",
+		"db prod",
+		"!",
+	}
+
+	for _, test := range tests {
+		if _, err := Parse(test); err == nil {
+			t.Errorf("expected an error for %q", test)
+		}
+	}
+}
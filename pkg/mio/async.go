@@ -0,0 +1,298 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package mio
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+var (
+	ErrQueueFull      = fmt.Errorf("notification queue is full")
+	ErrNotifierClosed = fmt.Errorf("notifier is closed")
+)
+
+// OverflowPolicy selects what Notify does when the queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for room in the queue.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest queued message to make room.
+	OverflowDropOldest
+	// OverflowDropNewest drops the new message, Notify returns
+	// ErrQueueFull.
+	OverflowDropNewest
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowDropNewest:
+		return "drop-newest"
+	}
+	return "OverflowPolicy(" + strconv.Itoa(int(p)) + ")"
+}
+
+// AsyncPolicy configures the queue and workers used by Notify.
+type AsyncPolicy struct {
+	// QueueSize is the number of messages the queue holds, defaults to
+	// 1024.
+	QueueSize int
+	// Workers is the number of messages broadcast concurrently, defaults
+	// to 4.
+	Workers  int
+	Overflow OverflowPolicy
+	// OnReport is called with the report of every message broadcast by
+	// the workers.
+	OnReport func(msg Message, report Report)
+}
+
+// asyncQueue is the bounded queue of messages waiting to be broadcast by
+// the workers.
+type asyncQueue struct {
+	mu       sync.Mutex
+	policy   AsyncPolicy
+	items    []Message
+	inFlight int
+	closed   bool
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	// idle are closed once the queue is empty and nothing is in flight,
+	// and done once the queue is closed.
+	idle []chan struct{}
+	done chan struct{}
+}
+
+func newAsyncQueue(p AsyncPolicy) *asyncQueue {
+	if p.QueueSize <= 0 {
+		p.QueueSize = 1024
+	}
+	if p.Workers <= 0 {
+		p.Workers = 4
+	}
+	q := &asyncQueue{policy: p, done: make(chan struct{})}
+	q.notEmpty = sync.NewCond(&q.mu)
+	q.notFull = sync.NewCond(&q.mu)
+	return q
+}
+
+// push adds msg to the queue according to the overflow policy. It returns
+// whether a queued message was dropped to make room.
+func (q *asyncQueue) push(msg Message) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	dropped := false
+	for !q.closed && len(q.items) >= q.policy.QueueSize {
+		switch q.policy.Overflow {
+		case OverflowDropNewest:
+			return false, ErrQueueFull
+		case OverflowDropOldest:
+			q.items = q.items[1:]
+			dropped = true
+		default:
+			q.notFull.Wait()
+		}
+	}
+	if q.closed {
+		return false, ErrNotifierClosed
+	}
+
+	q.items = append(q.items, msg)
+	q.notEmpty.Signal()
+	return dropped, nil
+}
+
+// pop waits for a message and marks it in flight. It returns false once
+// the queue is closed.
+func (q *asyncQueue) pop() (Message, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for !q.closed && len(q.items) == 0 {
+		q.notEmpty.Wait()
+	}
+	if q.closed {
+		return Message{}, false
+	}
+
+	msg := q.items[0]
+	q.items = q.items[1:]
+	q.inFlight++
+	q.notFull.Signal()
+	return msg, true
+}
+
+// release marks a message popped from the queue as sent.
+func (q *asyncQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.inFlight--
+	q.wakeIdle()
+}
+
+// wakeIdle releases the flushes once the queue is idle. The caller must
+// hold the lock.
+func (q *asyncQueue) wakeIdle() {
+	if len(q.items) > 0 || q.inFlight > 0 {
+		return
+	}
+	for _, ch := range q.idle {
+		close(ch)
+	}
+	q.idle = nil
+}
+
+// flush waits for the queue to be empty and the messages in flight to be
+// sent, or for ctx to be done. It fails with ErrNotifierClosed once the
+// queue is closed, since the messages left were discarded.
+func (q *asyncQueue) flush(ctx context.Context) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return ErrNotifierClosed
+	}
+	if len(q.items) == 0 && q.inFlight == 0 {
+		q.mu.Unlock()
+		return nil
+	}
+	idle := make(chan struct{})
+	q.idle = append(q.idle, idle)
+	q.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-q.done:
+		return ErrNotifierClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close stops the workers after the messages in flight and discards the
+// queued ones, waking up the pushes, pops and flushes waiting on the
+// queue. It returns the number of messages discarded.
+func (q *asyncQueue) close() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return 0
+	}
+	discarded := len(q.items)
+	q.items = nil
+	q.closed = true
+	q.idle = nil
+	close(q.done)
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+	return discarded
+}
+
+// SetAsync configures the queue and workers used by Notify. It must be
+// called before the first Notify, which otherwise starts them with the
+// defaults. It fails with ErrNotifierClosed once the Notifier is closed.
+func (n *Notifier) SetAsync(p AsyncPolicy) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		return ErrNotifierClosed
+	}
+	if n.async != nil {
+		return fmt.Errorf("async mode already started")
+	}
+	n.startAsync(p)
+	return nil
+}
+
+// startAsync creates the queue and starts the workers. The caller must
+// hold the lock.
+func (n *Notifier) startAsync(p AsyncPolicy) {
+	q := newAsyncQueue(p)
+	n.async = q
+
+	if n.stop == nil {
+		n.stop = make(chan struct{})
+	}
+	for i := 0; i < q.policy.Workers; i++ {
+		n.workers.Add(1)
+		go func(stop <-chan struct{}) {
+			defer n.workers.Done()
+			n.asyncWorker(q, stop)
+		}(n.stop)
+	}
+}
+
+// asyncWorker broadcasts the messages of the queue until it is closed.
+// Sends in flight are cancelled when the Notifier is closed.
+func (n *Notifier) asyncWorker(q *asyncQueue, stop <-chan struct{}) {
+	ctx, cancel := stopContext(stop)
+	defer cancel()
+
+	for {
+		msg, ok := q.pop()
+		if !ok {
+			return
+		}
+
+		report := n.BroadcastContext(ctx, msg)
+		if q.policy.OnReport != nil {
+			q.policy.OnReport(msg, report)
+		}
+		q.release()
+	}
+}
+
+// Notify queues msg to be broadcast in the background and returns without
+// waiting for it to be sent. When the queue is full, Notify blocks or
+// drops a message as set by SetAsync. The outcome of the broadcast is
+// logged, counted in the metrics and passed to the policy's OnReport.
+func (n *Notifier) Notify(msg Message) error {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return ErrNotifierClosed
+	}
+	if n.async == nil {
+		n.startAsync(AsyncPolicy{})
+	}
+	q := n.async
+	n.mu.Unlock()
+
+	dropped, err := q.push(msg)
+	if dropped {
+		n.log().Warn("notification queue is full, dropped the oldest message")
+	}
+	return err
+}
+
+// Flush waits for the messages queued by Notify to be sent, or for ctx to
+// be done. It fails with ErrNotifierClosed if the Notifier is closed
+// first.
+func (n *Notifier) Flush(ctx context.Context) error {
+	n.mu.RLock()
+	q := n.async
+	n.mu.RUnlock()
+
+	if q == nil {
+		return nil
+	}
+	return q.flush(ctx)
+}
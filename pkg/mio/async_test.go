@@ -0,0 +1,179 @@
+package mio
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/phea/mio/pkg/service"
+)
+
+// TestNotify tests that Notify returns right away and that Flush waits for
+// the queued messages to be sent.
+func TestNotify(t *testing.T) {
+	var sent int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&sent, 1)
+	}))
+	defer ts.Close()
+
+	var (
+		mu      sync.Mutex
+		reports int
+	)
+	var n Notifier
+	n.Must("json://"+testHost(ts)+"/hook", service.SetTLS(false))
+	err := n.SetAsync(AsyncPolicy{Workers: 2, OnReport: func(msg Message, report Report) {
+		mu.Lock()
+		reports++
+		mu.Unlock()
+	}})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.SetAsync(AsyncPolicy{}); err == nil {
+		t.Errorf("expected an error when async mode is already started")
+	}
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := n.Notify(Message{Title: "title"}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if d := time.Since(start); d > 10*time.Millisecond {
+		t.Errorf("expected Notify not to wait for the sends, took %s", d)
+	}
+
+	if err := n.Flush(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got := atomic.LoadInt32(&sent); got != 4 {
+		t.Errorf("expected 4 messages sent after Flush, got %d", got)
+	}
+	mu.Lock()
+	if reports != 4 {
+		t.Errorf("expected 4 reports, got %d", reports)
+	}
+	mu.Unlock()
+
+	if err := n.Close(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Notify(Message{Title: "title"}); !errors.Is(err, ErrNotifierClosed) {
+		t.Errorf("expected ErrNotifierClosed, got %v", err)
+	}
+}
+
+// TestAsyncOverflow tests the overflow policies of the queue.
+func TestAsyncOverflow(t *testing.T) {
+	q := newAsyncQueue(AsyncPolicy{QueueSize: 2, Overflow: OverflowDropNewest})
+	q.push(Message{Title: "1"})
+	q.push(Message{Title: "2"})
+	if _, err := q.push(Message{Title: "3"}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected ErrQueueFull, got %v", err)
+	}
+
+	q = newAsyncQueue(AsyncPolicy{QueueSize: 2, Overflow: OverflowDropOldest})
+	q.push(Message{Title: "1"})
+	q.push(Message{Title: "2"})
+	if dropped, err := q.push(Message{Title: "3"}); !dropped || err != nil {
+		t.Errorf("expected the oldest message to be dropped, got %t, %v", dropped, err)
+	}
+	if msg, _ := q.pop(); msg.Title != "2" {
+		t.Errorf("expected message 2 first, got %s", msg.Title)
+	}
+
+	q = newAsyncQueue(AsyncPolicy{QueueSize: 1})
+	q.push(Message{Title: "1"})
+	pushed := make(chan error)
+	go func() {
+		_, err := q.push(Message{Title: "2"})
+		pushed <- err
+	}()
+	select {
+	case <-pushed:
+		t.Fatalf("expected push to block on a full queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+	q.pop()
+	if err := <-pushed; err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	if discarded := q.close(); discarded != 1 {
+		t.Errorf("expected 1 discarded message, got %d", discarded)
+	}
+	if _, ok := q.pop(); ok {
+		t.Errorf("expected pop to fail on a closed queue")
+	}
+}
+
+// TestAsyncClose tests that closing the queue wakes up the pops and
+// flushes waiting on it.
+func TestAsyncClose(t *testing.T) {
+	q := newAsyncQueue(AsyncPolicy{QueueSize: 2})
+	q.push(Message{Title: "1"})
+	q.pop() // in flight, never released.
+	q.push(Message{Title: "2"})
+
+	flushed := make(chan error)
+	go func() {
+		flushed <- q.flush(context.Background())
+	}()
+
+	empty := newAsyncQueue(AsyncPolicy{})
+	popped := make(chan bool)
+	go func() {
+		_, ok := empty.pop()
+		popped <- ok
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	q.close()
+	empty.close()
+
+	select {
+	case err := <-flushed:
+		if !errors.Is(err, ErrNotifierClosed) {
+			t.Errorf("expected ErrNotifierClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected flush to return once the queue is closed")
+	}
+	select {
+	case ok := <-popped:
+		if ok {
+			t.Errorf("expected pop to fail on a closed queue")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected pop to return once the queue is closed")
+	}
+
+	if err := q.flush(context.Background()); !errors.Is(err, ErrNotifierClosed) {
+		t.Errorf("expected ErrNotifierClosed, got %v", err)
+	}
+}
+
+// TestSetAsyncClosed tests that async mode cannot be started once the
+// Notifier is closed.
+func TestSetAsyncClosed(t *testing.T) {
+	var n Notifier
+	if err := n.Close(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.SetAsync(AsyncPolicy{Workers: 2}); !errors.Is(err, ErrNotifierClosed) {
+		t.Errorf("expected ErrNotifierClosed, got %v", err)
+	}
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.async != nil {
+		t.Errorf("expected no workers to be started")
+	}
+}
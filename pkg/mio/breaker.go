@@ -0,0 +1,222 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package mio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCircuitOpen = fmt.Errorf("route circuit is open")
+)
+
+// BreakerState is the state of a route's circuit breaker.
+type BreakerState int
+
+const (
+	// BreakerClosed lets every message through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails every message with ErrCircuitOpen.
+	BreakerOpen
+	// BreakerHalfOpen lets a single probe through to test the route.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "BreakerState(" + strconv.Itoa(int(s)) + ")"
+}
+
+// BreakerPolicy configures the circuit breakers of the routes. The zero
+// value disables them.
+type BreakerPolicy struct {
+	// Threshold is the number of consecutive failed deliveries, after
+	// retries, that opens the circuit.
+	Threshold int
+	// OpenTimeout is the time the circuit stays open before a probe is let
+	// through, defaults to 30 seconds.
+	OpenTimeout time.Duration
+	// Probes is the number of successful probes that close the circuit
+	// again, defaults to 1.
+	Probes int
+	// OnStateChange is called with the redacted route whenever the state
+	// of its circuit changes.
+	OnStateChange func(route string, from, to BreakerState)
+}
+
+// breaker is the circuit breaker of a route.
+type breaker struct {
+	mu       sync.Mutex
+	policy   BreakerPolicy
+	route    string // redacted, for OnStateChange
+	state    BreakerState
+	failures int
+	probes   int
+	probing  bool
+	openedAt time.Time
+}
+
+func newBreaker(p BreakerPolicy, route string) *breaker {
+	if p.OpenTimeout <= 0 {
+		p.OpenTimeout = 30 * time.Second
+	}
+	if p.Probes <= 0 {
+		p.Probes = 1
+	}
+	return &breaker{policy: p, route: route}
+}
+
+// setState changes the state and returns a function notifying the change,
+// to be called once the lock is released. The caller must hold the lock.
+func (b *breaker) setState(to BreakerState) func() {
+	from := b.state
+	b.state = to
+	b.failures, b.probes = 0, 0
+	if to == BreakerOpen {
+		b.openedAt = time.Now()
+	}
+
+	if from == to || b.policy.OnStateChange == nil {
+		return func() {}
+	}
+	return func() { b.policy.OnStateChange(b.route, from, to) }
+}
+
+// allow reports whether a delivery may go through, returning
+// ErrCircuitOpen if not. A half-open circuit lets one probe through at a
+// time.
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	notify := func() {}
+	defer func() { notify() }()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.policy.OpenTimeout {
+			return ErrCircuitOpen
+		}
+		notify = b.setState(BreakerHalfOpen)
+		b.probing = true
+		return nil
+	case BreakerHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// record updates the breaker with the outcome of a delivery that allow let
+// through. Cancelled deliveries and rate limited ones are not counted.
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	notify := func() {}
+	defer func() { notify() }()
+	defer b.mu.Unlock()
+
+	ignored := errors.Is(err, context.Canceled) || errors.Is(err, ErrRateLimited)
+	if b.state == BreakerHalfOpen {
+		b.probing = false
+		switch {
+		case ignored:
+		case err != nil:
+			notify = b.setState(BreakerOpen)
+		default:
+			b.probes++
+			if b.probes >= b.policy.Probes {
+				notify = b.setState(BreakerClosed)
+			}
+		}
+		return
+	}
+
+	switch {
+	case ignored:
+	case err != nil:
+		b.failures++
+		if b.failures >= b.policy.Threshold {
+			notify = b.setState(BreakerOpen)
+		}
+	default:
+		b.failures = 0
+	}
+}
+
+// release gives back the probe that allow let through for a delivery that
+// was not attempted, such as one dropped by its rate limit, so that the
+// next delivery may probe the route.
+func (b *breaker) release() {
+	b.mu.Lock()
+	if b.state == BreakerHalfOpen {
+		b.probing = false
+	}
+	b.mu.Unlock()
+}
+
+// State returns the current state of the breaker.
+func (b *breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// breakerFor returns the circuit breaker of the route, creating it from the
+// route's policy or def, nil if breakers are disabled for the route.
+func (r *route) breakerFor(def BreakerPolicy) *breaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.breaker == nil {
+		p := def
+		if r.breakerPolicy != nil {
+			p = *r.breakerPolicy
+		}
+		if p.Threshold <= 0 {
+			return nil
+		}
+		r.breaker = newBreaker(p, Redact(r.raw))
+	}
+	return r.breaker
+}
+
+// SetBreakerPolicy sets the circuit breaker policy of the routes that do
+// not override it with WithBreaker. The breakers of the routes that use the
+// Notifier's policy are reset.
+func (n *Notifier) SetBreakerPolicy(p BreakerPolicy) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.breaker = p
+	for _, r := range n.routes {
+		for _, m := range r.members() {
+			m.mu.Lock()
+			if m.breakerPolicy == nil {
+				m.breaker = nil
+			}
+			m.mu.Unlock()
+		}
+	}
+}
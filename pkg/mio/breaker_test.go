@@ -0,0 +1,139 @@
+package mio
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/phea/mio/pkg/service"
+)
+
+// TestBreaker tests that a route opens after consecutive failures, fails
+// fast while open and closes again after a successful probe.
+func TestBreaker(t *testing.T) {
+	var up, hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if atomic.LoadInt32(&up) == 0 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer ts.Close()
+
+	var (
+		mu          sync.Mutex
+		transitions []BreakerState
+	)
+	var n Notifier
+	n.SetBreakerPolicy(BreakerPolicy{
+		Threshold:   2,
+		OpenTimeout: 50 * time.Millisecond,
+		OnStateChange: func(route string, from, to BreakerState) {
+			mu.Lock()
+			transitions = append(transitions, to)
+			mu.Unlock()
+		},
+	})
+	n.Must("json://"+testHost(ts), service.SetTLS(false))
+
+	msg := Message{Title: "t"}
+	for i := 0; i < 2; i++ {
+		if res := n.BroadcastContext(context.Background(), msg).Results[0]; res.OK() {
+			t.Fatalf("expected the delivery to fail")
+		}
+	}
+
+	res := n.BroadcastContext(context.Background(), msg).Results[0]
+	if !errors.Is(res.Err, ErrCircuitOpen) {
+		t.Errorf("expected ErrCircuitOpen, got %v", res.Err)
+	}
+	if atomic.LoadInt32(&hits) != 2 {
+		t.Errorf("expected the open circuit to fail fast, got %d requests", hits)
+	}
+
+	atomic.StoreInt32(&up, 1)
+	time.Sleep(60 * time.Millisecond)
+	if res := n.BroadcastContext(context.Background(), msg).Results[0]; !res.OK() {
+		t.Errorf("expected the probe to succeed, got %v", res.Err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if len(transitions) != len(want) {
+		t.Fatalf("expected transitions %v, got %v", want, transitions)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Errorf("expected transitions %v, got %v", want, transitions)
+		}
+	}
+}
+
+// TestBreakerHalfOpenFailure tests that a failed probe opens the circuit
+// again.
+func TestBreakerHalfOpenFailure(t *testing.T) {
+	b := newBreaker(BreakerPolicy{Threshold: 1, OpenTimeout: time.Millisecond}, "json://host")
+	boom := errors.New("boom")
+
+	b.record(boom)
+	if b.State() != BreakerOpen {
+		t.Fatalf("expected the circuit to be open, got %v", b.State())
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected a probe to be allowed, got %v", err)
+	}
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected a single probe at a time, got %v", err)
+	}
+
+	b.record(boom)
+	if b.State() != BreakerOpen {
+		t.Errorf("expected the circuit to open again, got %v", b.State())
+	}
+}
+
+// TestBreakerProbeRateLimited tests that a probe dropped by a full rate
+// limit queue does not keep the circuit half-open for good.
+func TestBreakerProbeRateLimited(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	var n Notifier
+	policy := BreakerPolicy{Threshold: 2, OpenTimeout: 50 * time.Millisecond}
+	n.SetBreakerPolicy(policy)
+	n.Must("json://"+testHost(ts), service.SetTLS(false),
+		WithRateLimit(RateLimit{Rate: 0.01, Burst: 1, Mode: LimitQueue, QueueSize: 1}))
+	defer n.Close(context.Background())
+
+	// the first message is sent, the queue worker waits for the tokens of
+	// the second and the third fills the queue.
+	msg := Message{Title: "t"}
+	n.BroadcastContext(context.Background(), msg)
+	for i := 0; i < 2; i++ {
+		if res := n.BroadcastContext(context.Background(), msg).Results[0]; !res.Queued {
+			t.Fatalf("expected the message to be queued, got %+v", res)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// open the circuit while the queue is full.
+	n.routes[0].breakerFor(policy).record(errors.New("boom"))
+	time.Sleep(60 * time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		res := n.BroadcastContext(context.Background(), msg).Results[0]
+		if !errors.Is(res.Err, ErrRateLimited) {
+			t.Errorf("%d: expected ErrRateLimited, got %v", i, res.Err)
+		}
+	}
+}
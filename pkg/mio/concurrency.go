@@ -0,0 +1,79 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package mio
+
+import (
+	"context"
+)
+
+// semaphore bounds the number of concurrent operations.
+type semaphore chan struct{}
+
+func newSemaphore(max int) semaphore {
+	if max <= 0 {
+		return nil
+	}
+	return make(semaphore, max)
+}
+
+// acquire waits for a slot, or for ctx to be done.
+func (s semaphore) acquire(ctx context.Context) error {
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s semaphore) release() {
+	<-s
+}
+
+// middleware holds a slot during every attempt of next.
+func (s semaphore) middleware(next SendFunc) SendFunc {
+	return func(ctx context.Context, msg Message) error {
+		if err := s.acquire(ctx); err != nil {
+			return err
+		}
+		defer s.release()
+		return next(ctx, msg)
+	}
+}
+
+// SetConcurrency sets the maximum number of routes a broadcast delivers
+// to concurrently, 0 for no limit. The limit is shared by all broadcasts
+// of the Notifier, the ones started before the call excepted.
+func (n *Notifier) SetConcurrency(max int) {
+	n.mu.Lock()
+	n.sem = newSemaphore(max)
+	n.mu.Unlock()
+}
+
+// SetSchemeConcurrency sets the maximum number of messages sent
+// concurrently to the routes of a scheme, 0 for no limit. The limit
+// applies to each attempt, so a route waiting to retry does not hold it.
+func (n *Notifier) SetSchemeConcurrency(scheme string, max int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	sems := make(map[string]semaphore, len(n.schemeSems)+1)
+	for k, v := range n.schemeSems {
+		sems[k] = v
+	}
+	if sem := newSemaphore(max); sem != nil {
+		sems[scheme] = sem
+	} else {
+		delete(sems, scheme)
+	}
+	n.schemeSems = sems
+}
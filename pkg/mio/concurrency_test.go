@@ -0,0 +1,63 @@
+package mio
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/phea/mio/pkg/service"
+)
+
+// TestConcurrency tests the global and per scheme concurrency limits.
+func TestConcurrency(t *testing.T) {
+	tests := []struct {
+		global, scheme, max int
+	}{
+		{0, 0, 6},
+		{3, 0, 3},
+		{0, 2, 2},
+		{3, 1, 1},
+	}
+
+	for _, test := range tests {
+		ts := newTestServer(withDelay(20 * time.Millisecond))
+
+		var n Notifier
+		n.SetConcurrency(test.global)
+		n.SetSchemeConcurrency("json", test.scheme)
+		for i := 0; i < 6; i++ {
+			n.Must("json://"+ts.host()+"/hook", service.SetTLS(false))
+		}
+
+		report := n.BroadcastContext(context.Background(), Message{Title: "title"})
+		if report.Err() != nil {
+			t.Errorf("expected no error, got %v", report.Err())
+		}
+		if got := ts.maxConcurrent(); got != test.max {
+			t.Errorf("expected at most %d concurrent sends with limits %d and %d, got %d",
+				test.max, test.global, test.scheme, got)
+		}
+		ts.Close()
+	}
+}
+
+// TestConcurrencyCancel tests that routes waiting for a slot fail once the
+// context is done.
+func TestConcurrencyCancel(t *testing.T) {
+	ts := newTestServer(withDelay(20 * time.Millisecond))
+	defer ts.Close()
+
+	var n Notifier
+	n.SetConcurrency(1)
+	for i := 0; i < 3; i++ {
+		n.Must("json://"+ts.host()+"/hook", service.SetTLS(false))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	report := n.BroadcastContext(ctx, Message{Title: "title"})
+	if len(report.Failed()) != 3 {
+		t.Errorf("expected every route to fail, got %d failures", len(report.Failed()))
+	}
+}
@@ -0,0 +1,546 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package mio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/phea/mio/pkg/service"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigError is an invalid entry of a config file.
+type ConfigError struct {
+	Path string
+	// Line is the line of the entry, 0 if unknown.
+	Line int
+	// Entry names the entry, e.g. `route "ops"`, empty for errors about
+	// the whole file.
+	Entry string
+	Err   error
+}
+
+func (e *ConfigError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Path)
+	if e.Line > 0 {
+		b.WriteString(":" + strconv.Itoa(e.Line))
+	}
+	b.WriteString(": ")
+	if e.Entry != "" {
+		b.WriteString(e.Entry + ": ")
+	}
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigErrors are the errors found in a config file, one per invalid
+// entry.
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// configRoute is a route entry of a config file.
+type configRoute struct {
+	Name      string          `yaml:"name"`
+	URL       string          `yaml:"url"`
+	Tags      []string        `yaml:"tags"`
+	TLS       *bool           `yaml:"tls"`
+	Method    string          `yaml:"method"`
+	Timeout   string          `yaml:"timeout"`
+	Retries   string          `yaml:"retries"`
+	Backoff   string          `yaml:"backoff"`
+	Rate      string          `yaml:"rate"`
+	Burst     string          `yaml:"burst"`
+	Limit     string          `yaml:"limit"`
+	Digest    string          `yaml:"digest"`
+	DigestMax string          `yaml:"digest_max"`
+	Template  *configTemplate `yaml:"template"`
+}
+
+// configTemplate formats the messages sent to a route, with the message as
+// the data of the text/template templates.
+type configTemplate struct {
+	Title string `yaml:"title"`
+	Body  string `yaml:"body"`
+}
+
+// configGroup is a group entry of a config file. The routes of a failover
+// group make up a failover chain named after the group, the routes of
+// other groups are tagged with the group's name.
+type configGroup struct {
+	Name     string   `yaml:"name"`
+	Routes   []string `yaml:"routes"`
+	Failover bool     `yaml:"failover"`
+}
+
+// LoadConfig returns a Notifier with the routes declared in a JSON or YAML
+// config file, chosen by the file extension. For example:
+//
+//	routes:
+//	  - name: ops
+//	    url: json://hooks.example.com/ops
+//	    tags: [ops]
+//	    tls: true
+//	    method: PUT
+//	    timeout: 10s
+//	    retries: 3
+//	    backoff: 1s
+//	    rate: 30/m
+//	    digest: 5m
+//	    template:
+//	      title: "[prod] {{.Title}}"
+//	  - name: desktop
+//	    url: gnome://
+//	groups:
+//	  - name: pager
+//	    routes: [ops, desktop]
+//	    failover: true
+//	rules:
+//	  - name: page
+//	    severity: ">= critical"
+//	    routes: pager
+//
+// The route options are the query parameters and service options of the
+// same names, the rules those of SetRules. Invalid entries are reported as ConfigErrors, with the line
+// they are at.
+func LoadConfig(path string) (*Notifier, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Notifier{}
+	if err := n.loadConfig(path, data); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// loadConfig adds the routes declared in the config file to the Notifier.
+func (n *Notifier) loadConfig(path string, data []byte) error {
+	fileErr := func(line int, err error) error {
+		return ConfigErrors{{Path: path, Line: line, Err: err}}
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		// the YAML parser accepts JSON, but its errors would be confusing.
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			var syntax *json.SyntaxError
+			if errors.As(err, &syntax) {
+				return fileErr(1+bytes.Count(data[:syntax.Offset], []byte("\n")), err)
+			}
+			return fileErr(0, err)
+		}
+	case ".yaml", ".yml":
+	default:
+		return fileErr(0, fmt.Errorf("unsupported config format %q", filepath.Ext(path)))
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fileErr(0, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fileErr(root.Line, fmt.Errorf("expected a mapping of routes, groups and rules"))
+	}
+
+	l := &configLoader{n: n, path: path, byName: make(map[string]*route)}
+	l.checkKeys(root, "", "routes", "groups", "rules")
+	if routes := field(root, "routes"); routes != nil {
+		l.loadRoutes(routes)
+	}
+	if groups := field(root, "groups"); groups != nil {
+		l.loadGroups(groups)
+	}
+	if rules := field(root, "rules"); rules != nil {
+		l.loadRules(rules)
+	}
+	if len(l.errs) > 0 {
+		return l.errs
+	}
+	return l.add()
+}
+
+// configLoader builds the routes of a config file.
+type configLoader struct {
+	n    *Notifier
+	path string
+	errs ConfigErrors
+
+	routes []*route // in the order of the file
+	byName map[string]*route
+	chains []*route
+	// inChain holds the routes taken by failover groups.
+	inChain map[*route]bool
+	rules   []rule
+}
+
+func (l *configLoader) errorf(node *yaml.Node, entry string, format string, args ...interface{}) {
+	l.errs = append(l.errs, &ConfigError{
+		Path:  l.path,
+		Line:  node.Line,
+		Entry: entry,
+		Err:   fmt.Errorf(format, args...),
+	})
+}
+
+// checkKeys reports the keys of the mapping that are not allowed.
+func (l *configLoader) checkKeys(node *yaml.Node, entry string, allowed ...string) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		ok := false
+		for _, a := range allowed {
+			ok = ok || key.Value == a
+		}
+		if !ok {
+			l.errorf(key, entry, "unknown field %q", key.Value)
+		}
+	}
+}
+
+// field returns the value of the key in the mapping, nil if it has none.
+func field(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// routeKeys are the fields of a route entry.
+var routeKeys = []string{
+	"name", "url", "tags", "tls", "method", "timeout", "retries", "backoff",
+	"rate", "burst", "limit", "digest", "digest_max", "template",
+}
+
+func (l *configLoader) loadRoutes(node *yaml.Node) {
+	if node.Kind != yaml.SequenceNode {
+		l.errorf(node, "routes", "expected a list of routes")
+		return
+	}
+
+	for i, item := range node.Content {
+		entry := "route " + strconv.Itoa(i+1)
+		if item.Kind != yaml.MappingNode {
+			l.errorf(item, entry, "expected a mapping")
+			continue
+		}
+		if name := field(item, "name"); name != nil && name.Value != "" {
+			entry = "route " + strconv.Quote(name.Value)
+		}
+
+		before := len(l.errs)
+		l.checkKeys(item, entry, routeKeys...)
+		if t := field(item, "template"); t != nil && t.Kind == yaml.MappingNode {
+			l.checkKeys(t, entry, "title", "body")
+		}
+		if len(l.errs) > before {
+			continue
+		}
+
+		var cfg configRoute
+		if err := item.Decode(&cfg); err != nil {
+			l.errorf(item, entry, "%v", err)
+			continue
+		}
+
+		r, err := l.newRoute(item, entry, cfg)
+		if err != nil {
+			l.errs = append(l.errs, err.(*ConfigError))
+			continue
+		}
+		if cfg.Name != "" {
+			if _, dup := l.byName[cfg.Name]; dup {
+				l.errorf(field(item, "name"), entry, "%w", ErrDuplicateRoute)
+				continue
+			}
+			l.byName[cfg.Name] = r
+		}
+		l.routes = append(l.routes, r)
+	}
+}
+
+// newRoute initializes the route of an entry.
+func (l *configLoader) newRoute(item *yaml.Node, entry string, cfg configRoute) (*route, error) {
+	at := func(key string, err error) error {
+		node := item
+		if v := field(item, key); v != nil {
+			node = v
+		}
+		return &ConfigError{Path: l.path, Line: node.Line, Entry: entry, Err: err}
+	}
+
+	if cfg.URL == "" {
+		return nil, at("url", fmt.Errorf("missing url"))
+	}
+
+	var opts []service.Option
+	if cfg.Name != "" {
+		opts = append(opts, WithName(cfg.Name))
+	}
+	if len(cfg.Tags) > 0 {
+		opts = append(opts, WithTags(cfg.Tags...))
+	}
+	if cfg.TLS != nil {
+		opts = append(opts, service.SetTLS(*cfg.TLS))
+	}
+	if cfg.Method != "" {
+		opts = append(opts, service.SetMethod(strings.ToUpper(cfg.Method)))
+	}
+	if cfg.Timeout != "" {
+		d, err := time.ParseDuration(cfg.Timeout)
+		if err != nil || d <= 0 {
+			return nil, at("timeout", fmt.Errorf("invalid timeout %q", cfg.Timeout))
+		}
+		opts = append(opts, service.SetTimeout(d))
+	}
+	if cfg.Template != nil {
+		mw, err := templateMiddleware(cfg.Template.Title, cfg.Template.Body)
+		if err != nil {
+			return nil, at("template", err)
+		}
+		opts = append(opts, WithMiddleware(mw))
+	}
+
+	params := make(map[string]string)
+	for key, v := range map[string]string{
+		"retries":    cfg.Retries,
+		"backoff":    cfg.Backoff,
+		"rate":       cfg.Rate,
+		"burst":      cfg.Burst,
+		"limit":      cfg.Limit,
+		"digest":     cfg.Digest,
+		"digest_max": cfg.DigestMax,
+	} {
+		if v != "" {
+			params[key] = v
+		}
+	}
+	if len(params) > 0 {
+		opts = append(opts, withParams(params))
+	}
+
+	r, err := l.n.newRoute(cfg.URL, opts...)
+	if err != nil {
+		return nil, at("url", err)
+	}
+	return r, nil
+}
+
+func (l *configLoader) loadGroups(node *yaml.Node) {
+	if node.Kind != yaml.SequenceNode {
+		l.errorf(node, "groups", "expected a list of groups")
+		return
+	}
+
+	l.inChain = make(map[*route]bool)
+	for i, item := range node.Content {
+		entry := "group " + strconv.Itoa(i+1)
+		if item.Kind != yaml.MappingNode {
+			l.errorf(item, entry, "expected a mapping")
+			continue
+		}
+
+		before := len(l.errs)
+		l.checkKeys(item, entry, "name", "routes", "failover")
+		if len(l.errs) > before {
+			continue
+		}
+
+		var g configGroup
+		if err := item.Decode(&g); err != nil {
+			l.errorf(item, entry, "%v", err)
+			continue
+		}
+		if g.Name == "" {
+			l.errorf(item, entry, "missing name")
+			continue
+		}
+		entry = "group " + strconv.Quote(g.Name)
+		if len(g.Routes) == 0 {
+			l.errorf(item, entry, "no routes")
+			continue
+		}
+
+		members := make([]*route, 0, len(g.Routes))
+		for j, name := range g.Routes {
+			r, ok := l.byName[name]
+			switch {
+			case !ok:
+				l.errorf(field(item, "routes").Content[j], entry, "unknown route %q", name)
+			case g.Failover && l.inChain[r]:
+				l.errorf(field(item, "routes").Content[j], entry, "route %q is already in a failover group", name)
+			default:
+				members = append(members, r)
+			}
+		}
+		if len(members) < len(g.Routes) {
+			continue
+		}
+
+		if !g.Failover {
+			for _, r := range members {
+				r.tags = append(r.tags, g.Name)
+			}
+			continue
+		}
+		for _, r := range members {
+			l.inChain[r] = true
+		}
+		l.chains = append(l.chains, &route{name: g.Name, scheme: "failover", chain: members})
+	}
+}
+
+func (l *configLoader) loadRules(node *yaml.Node) {
+	if node.Kind != yaml.SequenceNode {
+		l.errorf(node, "rules", "expected a list of rules")
+		return
+	}
+
+	for i, item := range node.Content {
+		entry := "rule " + strconv.Itoa(i+1)
+		if item.Kind != yaml.MappingNode {
+			l.errorf(item, entry, "expected a mapping")
+			continue
+		}
+		if name := field(item, "name"); name != nil && name.Value != "" {
+			entry = "rule " + strconv.Quote(name.Value)
+		}
+
+		before := len(l.errs)
+		l.checkKeys(item, entry, "name", "severity", "routes")
+		if len(l.errs) > before {
+			continue
+		}
+
+		var r Rule
+		if err := item.Decode(&r); err != nil {
+			l.errorf(item, entry, "%v", err)
+			continue
+		}
+		at := func(key string) *yaml.Node {
+			if v := field(item, key); v != nil {
+				return v
+			}
+			return item
+		}
+		match, err := parseSeverityCond(r.Severity)
+		if err != nil {
+			l.errorf(at("severity"), entry, "%v", err)
+			continue
+		}
+		routes, err := ParseSelector(r.Routes)
+		if err != nil {
+			l.errorf(at("routes"), entry, "invalid routes %q: %v", r.Routes, err)
+			continue
+		}
+		l.rules = append(l.rules, rule{name: r.Name, match: match, routes: routes})
+	}
+}
+
+// add adds the routes to the Notifier, followed by the failover chains,
+// and sets the rules. The routes of the chains are not added on their own.
+func (l *configLoader) add() error {
+	for _, r := range l.routes {
+		if l.inChain[r] {
+			continue
+		}
+		l.n.mu.Lock()
+		err := l.n.register(r, nil)
+		if err == nil {
+			l.n.routes = append(l.n.routes, r)
+		}
+		l.n.mu.Unlock()
+		if err != nil {
+			return ConfigErrors{{Path: l.path, Entry: "route " + strconv.Quote(r.name), Err: err}}
+		}
+	}
+
+	for _, c := range l.chains {
+		if err := l.n.addChain(c); err != nil {
+			return ConfigErrors{{Path: l.path, Entry: "group " + strconv.Quote(c.name), Err: err}}
+		}
+	}
+
+	if len(l.rules) > 0 {
+		l.n.mu.Lock()
+		l.n.rules = l.rules
+		l.n.mu.Unlock()
+	}
+	return nil
+}
+
+// templateMiddleware returns a middleware formatting the title and body of
+// the messages with text/template templates, an empty one leaving the
+// field as is.
+func templateMiddleware(title, body string) (Middleware, error) {
+	var tmpls [2]*template.Template
+	for i, text := range []string{title, body} {
+		if text == "" {
+			continue
+		}
+		t, err := template.New("").Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		tmpls[i] = t
+	}
+
+	return func(next SendFunc) SendFunc {
+		return func(ctx context.Context, msg Message) error {
+			fields := [2]*string{&msg.Title, &msg.Body}
+			var formatted [2]string
+			for i, t := range tmpls {
+				if t == nil {
+					formatted[i] = *fields[i]
+					continue
+				}
+				var b strings.Builder
+				if err := t.Execute(&b, msg); err != nil {
+					return err
+				}
+				formatted[i] = b.String()
+			}
+			msg.Title, msg.Body = formatted[0], formatted[1]
+			return next(ctx, msg)
+		}
+	}, nil
+}
@@ -0,0 +1,205 @@
+package mio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// writeConfig writes a config file in a temporary directory.
+func writeConfig(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestLoadConfig tests loading routes, options and groups from YAML.
+func TestLoadConfig(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		titles []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var payload struct{ Title string }
+		json.NewDecoder(r.Body).Decode(&payload)
+		mu.Lock()
+		titles = append(titles, r.URL.Path+" "+payload.Title)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	path := writeConfig(t, "mio.yaml", `
+routes:
+  - name: ops
+    url: json://`+testHost(ts)+`/ops
+    tags: [ops]
+    tls: false
+    method: put
+    timeout: 5s
+    retries: 2
+    backoff: 10ms
+    template:
+      title: "[prod] {{.Title}}"
+  - name: primary
+    url: json://`+testHost(ts)+`/primary
+    tls: false
+    method: PUT
+  - name: backup
+    url: xml://localhost/backup
+groups:
+  - name: pager
+    routes: [primary, backup]
+    failover: true
+  - name: team
+    routes: [ops]
+`)
+
+	n, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	routes := n.Routes()
+	if len(routes) != 2 || routes[0].ID != "ops" || routes[1].ID != "pager" {
+		t.Fatalf("unexpected routes %+v", routes)
+	}
+	if strings.Join(routes[0].Tags, ",") != "ops,team" {
+		t.Errorf("expected the group to tag its routes, got %v", routes[0].Tags)
+	}
+	if len(routes[1].Members) != 2 || routes[1].Members[0].ID != "primary" {
+		t.Errorf("expected a failover chain, got %+v", routes[1].Members)
+	}
+
+	report := n.BroadcastContext(context.Background(), Message{Title: "title"})
+	if report.Err() != nil {
+		t.Fatalf("expected no error, got %v", report.Err())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	got := strings.Join(titles, "|")
+	if !strings.Contains(got, "/ops [prod] title") || !strings.Contains(got, "/primary title") {
+		t.Errorf("unexpected messages %q", got)
+	}
+}
+
+// TestLoadConfigJSON tests loading a JSON config.
+func TestLoadConfigJSON(t *testing.T) {
+	path := writeConfig(t, "mio.json", `{
+  "routes": [
+    {"name": "desktop", "url": "gnome://", "digest": "1m", "digest_max": 5}
+  ]
+}`)
+
+	n, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if routes := n.Routes(); len(routes) != 1 || routes[0].Scheme != "gnome" {
+		t.Errorf("unexpected routes %+v", routes)
+	}
+}
+
+// TestLoadConfigRules tests loading routing rules.
+func TestLoadConfigRules(t *testing.T) {
+	path := writeConfig(t, "mio.yaml", `
+routes:
+  - name: ops
+    url: json://localhost/ops
+    tags: [ops]
+  - name: desktop
+    url: gnome://
+rules:
+  - name: page
+    severity: ">= critical"
+    routes: ops
+`)
+
+	n, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	n.SetDryRun(true)
+	report := n.BroadcastContext(context.Background(), Message{Title: "title", Severity: SeverityCritical})
+	if sent := report.Sent(); len(sent) != 1 || sent[0].Rule != "page" || sent[0].Name != "ops" {
+		t.Errorf("expected the page rule to select ops only, got %+v", report.Results)
+	}
+}
+
+// TestLoadConfigErrors tests that errors point at the invalid entries.
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name, data string
+		errs       []string
+	}{
+		{"bad.json", "{\n  \"routes\": [\n    {\"url\": }\n  ]\n}", []string{
+			"bad.json:3: invalid character",
+		}},
+		{"mio.toml", "", []string{`unsupported config format ".toml"`}},
+		{"mio.yaml", `
+routes:
+  - name: ops
+    url: json://localhost/ops
+    colour: red
+  - url: nope://localhost
+  - name: slow
+    url: json://localhost/slow
+    retries: lots
+  - name: team
+    url: json://localhost/team
+  - name: team
+    url: json://localhost/other
+groups:
+  - name: pager
+    routes: [team, missing]
+    failover: true
+rules:
+  - name: page
+    severity: ">= dire"
+    routes: team
+  - name: chat
+    routes: "team &&"
+`, []string{
+			`mio.yaml:5: route "ops": unknown field "colour"`,
+			`mio.yaml:6: route 2: invalid route nope://localhost: unknown scheme "nope"`,
+			`mio.yaml:8: route "slow": invalid retries "lots"`,
+			`mio.yaml:12: route "team": route name already in use`,
+			`mio.yaml:16: group "pager": unknown route "missing"`,
+			`mio.yaml:20: rule "page": invalid severity condition ">= dire"`,
+			`mio.yaml:23: rule "chat": invalid routes "team &&"`,
+		}},
+	}
+
+	for _, test := range tests {
+		_, err := LoadConfig(writeConfig(t, test.name, test.data))
+		var errs ConfigErrors
+		if !errors.As(err, &errs) {
+			t.Errorf("%s: expected ConfigErrors, got %v", test.name, err)
+			continue
+		}
+		if len(errs) != len(test.errs) {
+			t.Errorf("%s: expected %d errors, got:\n%v", test.name, len(test.errs), err)
+			continue
+		}
+		for i, want := range test.errs {
+			if got := errs[i].Error(); !strings.Contains(got, want) {
+				t.Errorf("%s: expected %q in %q", test.name, want, got)
+			}
+		}
+	}
+}
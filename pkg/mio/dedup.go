@@ -0,0 +1,392 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package mio
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DedupEntry is the state of a message fingerprint within its window.
+type DedupEntry struct {
+	// Title and Severity of the first message, used for the follow-up.
+	Title    string   `json:"title"`
+	Severity Severity `json:"severity"`
+	// First is the time the first message of the window was sent.
+	First time.Time `json:"first"`
+	// Suppressed is the number of duplicates suppressed so far.
+	Suppressed int `json:"suppressed"`
+	// Selector is the selector the message was broadcast with, see
+	// selectorKey, empty for every route. The follow-up is broadcast with
+	// it.
+	Selector string `json:"selector,omitempty"`
+}
+
+// DedupStore holds the fingerprints of the messages sent recently.
+type DedupStore interface {
+	// Update calls fn with the entries of the store, keyed by fingerprint,
+	// and saves the changes fn made to them. Updates must be atomic with
+	// respect to the other users of the store.
+	Update(fn func(entries map[string]DedupEntry)) error
+}
+
+// MemoryDedupStore is a DedupStore kept in memory.
+type MemoryDedupStore struct {
+	mu      sync.Mutex
+	entries map[string]DedupEntry
+}
+
+// NewMemoryDedupStore returns an empty in-memory store.
+func NewMemoryDedupStore() *MemoryDedupStore {
+	return &MemoryDedupStore{entries: make(map[string]DedupEntry)}
+}
+
+// Update calls fn with the entries of the store.
+func (s *MemoryDedupStore) Update(fn func(entries map[string]DedupEntry)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.entries)
+	return nil
+}
+
+// lockTimeout is the time after which the lock of a FileDedupStore is
+// considered abandoned by a crashed process.
+const lockTimeout = 10 * time.Second
+
+// FileDedupStore is a DedupStore kept in a JSON file, so that processes
+// running one after the other, or side by side, share it. Updates are
+// serialized with a lock file next to the store.
+type FileDedupStore struct {
+	path string
+}
+
+// NewFileDedupStore returns a store kept in the file at path. The file is
+// created on the first update.
+func NewFileDedupStore(path string) *FileDedupStore {
+	return &FileDedupStore{path: path}
+}
+
+// Update loads the entries from the file, calls fn with them and writes
+// them back, holding the lock file in the meantime.
+func (s *FileDedupStore) Update(fn func(entries map[string]DedupEntry)) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries := make(map[string]DedupEntry)
+	data, err := os.ReadFile(s.path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return fmt.Errorf("invalid dedup store %s: %w", s.path, err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	fn(entries)
+
+	if data, err = json.Marshal(entries); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// lock creates the lock file, waiting for other processes to release it.
+// A lock older than lockTimeout is taken over.
+func (s *FileDedupStore) lock() (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return nil, err
+	}
+
+	path := s.path + ".lock"
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > lockTimeout {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for lock %s", path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// DedupPolicy configures duplicate suppression.
+type DedupPolicy struct {
+	// Window is the time during which repeats of a message are
+	// suppressed, starting when the first one is sent.
+	Window time.Duration
+	// Store holds the fingerprints, defaults to a MemoryDedupStore.
+	Store DedupStore
+	// Key returns the string a message is fingerprinted by. It defaults to
+	// the message's DedupKey if set, and its title and body otherwise.
+	// The selector the message is broadcast with is part of the
+	// fingerprint either way.
+	Key func(Message) string
+	// FlushOnClose makes Close send the follow-ups of the windows still
+	// open, and reset their count of duplicates, rather than leave them to
+	// be sent once the windows close. It is meant for stores that are not
+	// shared, since a short-lived Notifier sharing a store would otherwise
+	// report every repeat it suppressed right away.
+	FlushOnClose bool
+
+	// selectors are the selectors the messages were broadcast with, by
+	// key, to broadcast the follow-ups with.
+	selectors *selectorSet
+}
+
+// selectorSet remembers selectors by key.
+type selectorSet struct {
+	mu sync.Mutex
+	m  map[string]Selector
+}
+
+// selectorKey returns the string identifying the selector in the dedup
+// entries: the expression of the selectors returned by ParseSelector, or
+// of any selector implementing fmt.Stringer, empty for a nil selector.
+func selectorKey(sel Selector) string {
+	switch s := sel.(type) {
+	case nil:
+		return ""
+	case fmt.Stringer:
+		return s.String()
+	}
+	return fmt.Sprintf("%T(%v)", sel, sel)
+}
+
+// remember records the selector of a message, and returns its key.
+func (p *DedupPolicy) remember(sel Selector) string {
+	key := selectorKey(sel)
+	if sel == nil || p.selectors == nil {
+		return key
+	}
+
+	p.selectors.mu.Lock()
+	p.selectors.m[key] = sel
+	p.selectors.mu.Unlock()
+	return key
+}
+
+// selector returns the selector of the key: the one remembered, or the
+// one parsed from the key for entries written by another process.
+func (p *DedupPolicy) selector(key string) (Selector, error) {
+	if key == "" {
+		return nil, nil
+	}
+	if p.selectors != nil {
+		p.selectors.mu.Lock()
+		sel, ok := p.selectors.m[key]
+		p.selectors.mu.Unlock()
+		if ok {
+			return sel, nil
+		}
+	}
+	return ParseSelector(key)
+}
+
+// fingerprint returns the hash of the message's dedup key and the key of
+// the selector it is broadcast with.
+func (p *DedupPolicy) fingerprint(msg Message, sel string) string {
+	var key string
+	switch {
+	case p.Key != nil:
+		key = p.Key(msg)
+	case msg.DedupKey != "":
+		key = "key\x00" + msg.DedupKey
+	default:
+		key = "msg\x00" + msg.Title + "\x00" + msg.Body
+	}
+	if sel != "" {
+		key += "\x00sel\x00" + sel
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// followUp is the message reporting the duplicates suppressed in a
+// window, and the selector of the duplicates.
+type followUp struct {
+	msg      Message
+	selector string
+}
+
+// check records the message and reports whether it is a duplicate. It
+// also returns the follow-ups of the windows that closed.
+func (p *DedupPolicy) check(sel Selector, msg Message, now time.Time) (bool, []followUp, error) {
+	selKey := p.remember(sel)
+	key := p.fingerprint(msg, selKey)
+
+	var (
+		dup       bool
+		followUps []followUp
+	)
+	err := p.Store.Update(func(entries map[string]DedupEntry) {
+		followUps = p.expire(entries, now, false)
+
+		if e, ok := entries[key]; ok {
+			e.Suppressed++
+			entries[key] = e
+			dup = true
+			return
+		}
+		entries[key] = DedupEntry{Title: msg.Title, Severity: msg.Severity, First: now, Selector: selKey}
+	})
+	return dup, followUps, err
+}
+
+// sweep removes the entries whose window closed and returns their
+// follow-ups. With all set, it also returns the follow-ups of the open
+// windows, whose entries are kept, so that their repeats are still
+// suppressed, but with their count of duplicates reset.
+func (p *DedupPolicy) sweep(now time.Time, all bool) ([]followUp, error) {
+	var followUps []followUp
+	err := p.Store.Update(func(entries map[string]DedupEntry) {
+		followUps = p.expire(entries, now, all)
+	})
+	return followUps, err
+}
+
+// expire removes the entries whose window closed and returns a follow-up
+// for each one that suppressed duplicates, see sweep.
+func (p *DedupPolicy) expire(entries map[string]DedupEntry, now time.Time, all bool) []followUp {
+	var followUps []followUp
+	for k, e := range entries {
+		closed := now.Sub(e.First) >= p.Window
+		if !closed && !all {
+			continue
+		}
+
+		if e.Suppressed > 0 {
+			followUps = append(followUps, followUp{
+				msg: Message{
+					Title:     e.Title,
+					Body:      fmt.Sprintf("suppressed %d duplicates in %s", e.Suppressed, p.Window),
+					Severity:  e.Severity,
+					Timestamp: now,
+				},
+				selector: e.Selector,
+			})
+		}
+
+		if closed {
+			delete(entries, k)
+		} else {
+			e.Suppressed = 0
+			entries[k] = e
+		}
+	}
+	return followUps
+}
+
+// sendFollowUps broadcasts the follow-ups to the routes their duplicates
+// were meant for.
+func (n *Notifier) sendFollowUps(ctx context.Context, p *DedupPolicy, followUps []followUp) {
+	for _, f := range followUps {
+		sel, err := p.selector(f.selector)
+		if err != nil {
+			n.log().Warn("dedup follow-up dropped, unknown selector",
+				"selector", f.selector, "title", f.msg.Title)
+			continue
+		}
+		n.broadcast(ctx, sel, f.msg)
+	}
+}
+
+// flushFollowUps sends the follow-ups of the windows that closed, and of
+// the ones still open if the policy flushes them on Close.
+func (n *Notifier) flushFollowUps(ctx context.Context) {
+	n.mu.RLock()
+	p := n.dedup
+	n.mu.RUnlock()
+	if p == nil {
+		return
+	}
+
+	followUps, err := p.sweep(time.Now(), p.FlushOnClose)
+	if err != nil {
+		n.log().Warn("dedup follow-ups not flushed", "error", err)
+	}
+	n.sendFollowUps(ctx, p, followUps)
+}
+
+// SetDedup makes the Notifier suppress the repeats of a message sent
+// within the policy's window. When a window closes a follow-up reporting
+// the number of suppressed duplicates is broadcast, either by a worker
+// running until the Notifier is closed or, with a store shared between
+// processes, by the next broadcast of any of them.
+func (n *Notifier) SetDedup(p DedupPolicy) {
+	if p.Store == nil {
+		p.Store = NewMemoryDedupStore()
+	}
+	p.selectors = &selectorSet{m: make(map[string]Selector)}
+
+	n.mu.Lock()
+	n.dedup = &p
+	n.mu.Unlock()
+
+	interval := p.Window / 2
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	n.goWorker(func(stop <-chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			n.mu.RLock()
+			current := n.dedup
+			n.mu.RUnlock()
+			if current != &p {
+				// replaced by another policy.
+				return
+			}
+
+			followUps, _ := p.sweep(time.Now(), false)
+			ctx, cancel := stopContext(stop)
+			n.sendFollowUps(ctx, &p, followUps)
+			cancel()
+		}
+	})
+}
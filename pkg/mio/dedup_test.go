@@ -0,0 +1,167 @@
+package mio
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phea/mio/pkg/service"
+)
+
+// TestDedupMemory tests that repeats are suppressed and that a follow-up is
+// sent once the window closes.
+func TestDedupMemory(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	var n Notifier
+	n.SetDedup(DedupPolicy{Window: 50 * time.Millisecond})
+	defer n.Close(context.Background())
+	n.Must("json://"+ts.host(), service.SetTLS(false))
+
+	msg := Message{Title: "check failed", Body: "db is down"}
+	if report := n.BroadcastContext(context.Background(), msg); report.Suppressed {
+		t.Fatalf("expected the first message to be sent")
+	}
+	for i := 0; i < 3; i++ {
+		if report := n.BroadcastContext(context.Background(), msg); !report.Suppressed {
+			t.Fatalf("expected the repeat to be suppressed")
+		}
+	}
+
+	other := Message{Title: "check failed", Body: "db is down", DedupKey: "other"}
+	if report := n.BroadcastContext(context.Background(), other); report.Suppressed {
+		t.Errorf("expected a message with another dedup key to be sent")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(ts.bodies()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	got := ts.bodies()
+	if len(got) != 3 || got[2] != "suppressed 3 duplicates in 50ms" {
+		t.Errorf("expected a follow-up for the suppressed duplicates, got %q", got)
+	}
+}
+
+// TestDedupFileStore tests that notifiers sharing a file store suppress
+// each other's repeats.
+func TestDedupFileStore(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "dedup.json")
+	newNotifier := func() *Notifier {
+		n := &Notifier{}
+		n.mu.Lock()
+		n.dedup = &DedupPolicy{Window: 50 * time.Millisecond, Store: NewFileDedupStore(path)}
+		n.mu.Unlock()
+		n.Must("json://"+ts.host(), service.SetTLS(false))
+		return n
+	}
+
+	msg := Message{Title: "job failed", Body: "exit 1"}
+	if report := newNotifier().BroadcastContext(context.Background(), msg); report.Suppressed {
+		t.Fatalf("expected the first message to be sent")
+	}
+	if report := newNotifier().BroadcastContext(context.Background(), msg); !report.Suppressed {
+		t.Fatalf("expected the repeat from another notifier to be suppressed")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if report := newNotifier().BroadcastContext(context.Background(), msg); report.Suppressed {
+		t.Fatalf("expected the message to be sent once the window closed")
+	}
+
+	got := ts.bodies()
+	want := []string{"exit 1", "suppressed 1 duplicates in 50ms", "exit 1"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+}
+
+// TestDedupSelector tests that repeats are told apart by the selector they
+// are broadcast with, that their follow-ups only go to the selected routes,
+// and that the pending follow-ups are sent on Close if asked to.
+func TestDedupSelector(t *testing.T) {
+	db := newTestServer()
+	defer db.Close()
+	web := newTestServer()
+	defer web.Close()
+
+	var n Notifier
+	n.SetDedup(DedupPolicy{Window: time.Hour, FlushOnClose: true})
+	n.Must("json://"+db.host(), service.SetTLS(false), WithTags("db"))
+	n.Must("json://"+web.host(), service.SetTLS(false), WithTags("web"))
+
+	sel, err := ParseSelector("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := Message{Title: "check failed", Body: "db is down"}
+	ctx := context.Background()
+	if report := n.BroadcastTo(ctx, sel, msg); report.Suppressed {
+		t.Fatalf("expected the first message to be sent")
+	}
+	for i := 0; i < 2; i++ {
+		if report := n.BroadcastTo(ctx, sel, msg); !report.Suppressed {
+			t.Fatalf("expected the repeat to be suppressed")
+		}
+	}
+	if report := n.BroadcastTo(ctx, nil, msg); report.Suppressed {
+		t.Fatalf("expected the message broadcast to every route to be sent")
+	}
+
+	if err := n.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	got := db.bodies()
+	if len(got) != 3 || got[2] != "suppressed 2 duplicates in 1h0m0s" {
+		t.Errorf("expected the follow-up to be flushed on Close, got %q", got)
+	}
+	if got := web.bodies(); len(got) != 1 {
+		t.Errorf("expected the follow-up to skip the routes not selected, got %q", got)
+	}
+}
+
+// TestDedupSharedStoreClose tests that short-lived notifiers sharing a
+// store do not report the repeats they suppressed when closed, and that
+// the follow-up is sent once the window closed.
+func TestDedupSharedStoreClose(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	store := NewFileDedupStore(filepath.Join(t.TempDir(), "dedup.json"))
+	run := func() {
+		var n Notifier
+		n.SetDedup(DedupPolicy{Window: 100 * time.Millisecond, Store: store})
+		n.Must("json://"+ts.host(), service.SetTLS(false))
+		n.BroadcastContext(context.Background(), Message{Title: "job failed", Body: "exit 1"})
+		if err := n.Close(context.Background()); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		run()
+	}
+	if got := ts.bodies(); len(got) != 1 || got[0] != "exit 1" {
+		t.Fatalf("expected the message to be sent once, got %q", got)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	run()
+	want := []string{"exit 1", "suppressed 4 duplicates in 100ms", "exit 1"}
+	if got := ts.bodies(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,284 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package mio
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// settings is a snapshot of the Notifier wide settings, taken when a
+// broadcast starts so that it is not affected by concurrent changes.
+type settings struct {
+	retry   RetryPolicy
+	outbox  *Outbox
+	limits  map[string]*limiter
+	breaker BreakerPolicy
+	rules   []rule
+
+	middleware []Middleware
+	transport  SendFunc
+	sem        semaphore
+	schemeSems map[string]semaphore
+	dryRun     bool
+}
+
+// snapshot returns the routes and settings of the Notifier.
+func (n *Notifier) snapshot() ([]*route, settings) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	routes := make([]*route, len(n.routes))
+	copy(routes, n.routes)
+	return routes, settings{
+		retry:   n.retry,
+		outbox:  n.outbox,
+		limits:  n.limits,
+		breaker: n.breaker,
+		rules:   n.rules,
+
+		middleware: n.middleware,
+		transport:  n.transport,
+		sem:        n.sem,
+		schemeSems: n.schemeSems,
+		dryRun:     n.dryRun,
+	}
+}
+
+// delivery is a message on its way to a route.
+type delivery struct {
+	route *route
+	msg   Message
+	s     settings
+
+	// outboxID is the entry of the message in the outbox, 0 if the message
+	// is not in the outbox.
+	outboxID uint64
+	// failover is set for the members of a failover chain but the last,
+	// which fail over rather than queue messages behind their rate limit.
+	failover bool
+}
+
+// newDelivery returns the delivery of msg to the route, writing it to the
+// outbox first if there is one. Messages that could not be written to the
+// outbox are still delivered.
+func (n *Notifier) newDelivery(r *route, msg Message, s settings) *delivery {
+	d := &delivery{route: r, msg: msg, s: s}
+	if s.outbox != nil {
+		if id, err := s.outbox.put(r.raw, msg); err == nil {
+			d.outboxID = id
+		}
+	}
+	return d
+}
+
+// deliver sends the message through the route's service, applying the
+// circuit breaker, rate limits and retries, and records the outcome.
+func (n *Notifier) deliver(ctx context.Context, d *delivery) Result {
+	r := d.route
+	res := r.result()
+
+	if b := r.breakerFor(d.s.breaker); b != nil {
+		if err := b.allow(); err != nil {
+			res.Err = err
+			d.finish(&res)
+			n.record(res)
+			return res
+		}
+	}
+
+	gate := d.gate()
+	if gate != nil {
+		if gate.mode == LimitQueue && d.failover {
+			gate.mode = LimitDrop
+		}
+		res.Limit = &LimitState{Mode: gate.mode}
+		if gate.mode == LimitQueue {
+			queued, err := n.enqueue(d, gate)
+			switch {
+			case err != nil:
+				if b := r.breakerFor(d.s.breaker); b != nil {
+					b.release()
+				}
+				res.Err = err
+				res.Limit.Dropped = errors.Is(err, ErrRateLimited)
+				res.Limit.Tokens = gate.tokens()
+				d.finish(&res)
+				n.record(res)
+				return res
+			case queued:
+				res.Queued, res.Limit.Queued = true, true
+				res.Limit.Tokens = gate.tokens()
+				return res
+			}
+			// the first attempt already got its tokens.
+			gate = &rateGate{limiters: gate.limiters, mode: LimitBlock, skip: true}
+		}
+	}
+
+	d.attempt(ctx, gate, &res)
+	d.finish(&res)
+	n.record(res)
+	return res
+}
+
+// enqueue queues the delivery if the gate has no tokens left, or if
+// deliveries are already queued behind it, so that they are sent in
+// order. Otherwise it takes the tokens of the first attempt. It reports
+// whether the delivery was queued, and fails with ErrRateLimited if the
+// queue is full, or with ErrNotifierClosed if the Notifier is closed. The
+// queue is checked and the tokens taken under the same locks, so that a
+// concurrent delivery cannot overtake the queued ones.
+func (n *Notifier) enqueue(d *delivery, g *rateGate) (bool, error) {
+	defer g.lock()()
+	l := g.owner()
+	if l.pending == 0 && g.take() {
+		return false, nil
+	}
+
+	if l.queue == nil {
+		q := make(chan *delivery, l.queueSize)
+		started := n.goWorker(func(stop <-chan struct{}) {
+			n.runQueue(l, q, stop)
+		})
+		if !started {
+			return false, ErrNotifierClosed
+		}
+		l.queue = q
+	}
+	select {
+	case l.queue <- d:
+		l.pending++
+		return true, nil
+	default:
+		return false, ErrRateLimited
+	}
+}
+
+// runQueue sends the deliveries queued behind the limiter one at a time,
+// waiting for their tokens. Once the Notifier is closed, the deliveries
+// left fail with ErrNotifierClosed without being sent.
+func (n *Notifier) runQueue(l *limiter, q chan *delivery, stop <-chan struct{}) {
+	ctx, cancel := stopContext(stop)
+	defer cancel()
+
+	for {
+		select {
+		case d := <-q:
+			n.sendQueued(ctx, l, d)
+		case <-stop:
+			l.mu.Lock()
+			l.queue = nil
+			l.mu.Unlock()
+			for {
+				select {
+				case d := <-q:
+					n.sendQueued(ctx, l, d)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// sendQueued delivers a queued message and records the outcome. Once the
+// Notifier is closed, the message fails with ErrNotifierClosed and is left
+// in the outbox, if any, to be replayed.
+func (n *Notifier) sendQueued(ctx context.Context, l *limiter, d *delivery) {
+	res := d.route.result()
+	res.Limit = &LimitState{Mode: LimitQueue, Queued: true}
+	if ctx.Err() != nil {
+		res.Err = ErrNotifierClosed
+		if b := d.route.breakerFor(d.s.breaker); b != nil {
+			b.release()
+		}
+	} else {
+		gate := d.gate()
+		gate.mode = LimitBlock
+		d.attempt(ctx, gate, &res)
+		if ctx.Err() != nil && errors.Is(res.Err, context.Canceled) {
+			// closed while waiting for its tokens.
+			res.Err = ErrNotifierClosed
+		}
+	}
+
+	l.mu.Lock()
+	l.pending--
+	l.mu.Unlock()
+
+	d.finish(&res)
+	n.record(res)
+}
+
+// attempt sends the message through the middlewares of the Notifier and
+// the route, then the built-in ones retrying according to the route's
+// policy or the Notifier's, and waiting for the rate limiters before every
+// attempt.
+func (d *delivery) attempt(ctx context.Context, gate *rateGate, res *Result) {
+	policy := d.s.retry
+	if d.route.retry != nil {
+		policy = *d.route.retry
+	}
+
+	send := SendFunc(d.route.send)
+	if d.s.transport != nil {
+		send = d.s.transport
+	}
+	if sem := d.s.schemeSems[d.route.scheme]; sem != nil {
+		send = sem.middleware(send)
+	}
+	if gate != nil {
+		send = gate.middleware(send)
+	}
+	send = policy.middleware(send)
+	send = wrap(send, d.route.middleware)
+	send = wrap(send, d.s.middleware)
+
+	start := time.Now()
+	res.Err = send(withRoute(withResult(ctx, res), d.route), d.msg)
+	res.Duration = time.Since(start)
+
+	if b := d.route.breakerFor(d.s.breaker); b != nil {
+		b.record(res.Err)
+	}
+}
+
+// finish acknowledges the outbox entry of a delivered message, moves the
+// one of a message that failed for good to the dead letters, or leaves it
+// pending for the outbox worker.
+func (d *delivery) finish(res *Result) {
+	o := d.s.outbox
+	if o == nil || d.outboxID == 0 {
+		return
+	}
+
+	switch {
+	case res.OK():
+		if err := o.ack(d.outboxID); err != nil {
+			o.release(d.outboxID)
+		}
+	case undelivered(res.Err):
+		o.release(d.outboxID)
+		res.Pending = true
+	case o.fail(d.outboxID, res.Err):
+		res.DeadLetter = true
+	default:
+		res.Pending = true
+	}
+}
+
+// send makes a single attempt at sending msg through the route's service.
+func (r *route) send(ctx context.Context, msg Message) error {
+	return redactError(r.svc.Send(ctx, msg), r.secrets)
+}
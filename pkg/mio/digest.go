@@ -0,0 +1,180 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package mio
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDigestMax is the number of messages listed in a digest unless
+// set otherwise.
+const DefaultDigestMax = 10
+
+// DigestPolicy makes a route accumulate messages and send them as a single
+// digest per interval.
+type DigestPolicy struct {
+	Interval time.Duration
+	// Max is the number of messages listed in a digest, the others are
+	// summarized by a "+N more" line. Defaults to DefaultDigestMax.
+	Max int
+}
+
+// digest accumulates the messages of a route until the next flush.
+type digest struct {
+	mu     sync.Mutex
+	policy DigestPolicy
+	msgs   []Message
+	timer  *time.Timer
+}
+
+func newDigest(p DigestPolicy) *digest {
+	if p.Max <= 0 {
+		p.Max = DefaultDigestMax
+	}
+	return &digest{policy: p}
+}
+
+// add buffers a message. The first message of an interval schedules the
+// flush.
+func (d *digest) add(msg Message, flush func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.msgs = append(d.msgs, msg)
+	if d.timer == nil {
+		d.timer = time.AfterFunc(d.policy.Interval, flush)
+	}
+}
+
+// take returns the buffered messages as a single digest message and resets
+// the buffer. It returns false if there is nothing to send.
+func (d *digest) take() (Message, bool) {
+	d.mu.Lock()
+	msgs := d.msgs
+	d.msgs = nil
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.mu.Unlock()
+
+	if len(msgs) == 0 {
+		return Message{}, false
+	}
+	return combine(msgs, d.policy.Max), true
+}
+
+// combine formats the messages as a list, keeping the highest priority and
+// severity among them.
+func combine(msgs []Message, max int) Message {
+	digest := Message{
+		Title:     fmt.Sprintf("Digest: %d notifications", len(msgs)),
+		Timestamp: time.Now(),
+	}
+	if len(msgs) == 1 {
+		digest.Title = "Digest: 1 notification"
+	}
+
+	var b strings.Builder
+	for i, msg := range msgs {
+		if msg.Priority > digest.Priority || i == 0 {
+			digest.Priority = msg.Priority
+		}
+		if msg.Severity > digest.Severity || i == 0 {
+			digest.Severity = msg.Severity
+		}
+
+		if i < max {
+			b.WriteString("- ")
+			b.WriteString(msg.Title)
+			if msg.Body != "" {
+				b.WriteString(": ")
+				b.WriteString(msg.Body)
+			}
+			b.WriteString("\n")
+		}
+	}
+	if len(msgs) > max {
+		fmt.Fprintf(&b, "+%d more\n", len(msgs)-max)
+	}
+
+	digest.Body = strings.TrimSuffix(b.String(), "\n")
+	return digest
+}
+
+// addToDigest buffers msg in the route's digest and returns the result
+// reporting it. Once the Notifier is closing, the pending digests were
+// sent or are being sent, so the message is rejected rather than buffered
+// to never be sent.
+func (n *Notifier) addToDigest(r *route, msg Message) Result {
+	// the message is added under the lock, so that it is either rejected
+	// or buffered before Close sends the pending digests. A digest being
+	// sent when Close is called is not cancelled, Close waits for it like
+	// for the ones it sends.
+	n.mu.RLock()
+	closed := n.closed
+	if !closed {
+		r.digest.add(msg, func() {
+			n.goWorker(func(<-chan struct{}) {
+				n.flushDigest(context.Background(), r)
+			})
+		})
+	}
+	n.mu.RUnlock()
+
+	if closed {
+		res := r.result()
+		res.Err = ErrNotifierClosed
+		n.record(res)
+		return res
+	}
+
+	res := r.result()
+	res.Digested = true
+	return res
+}
+
+// flushDigest sends the messages buffered by the route's digest.
+func (n *Notifier) flushDigest(ctx context.Context, r *route) Result {
+	msg, ok := r.digest.take()
+	if !ok {
+		return Result{}
+	}
+
+	_, s := n.snapshot()
+	return n.deliver(ctx, n.newDelivery(r, msg, s))
+}
+
+// flushDigests sends the messages buffered by every route in digest mode.
+func (n *Notifier) flushDigests(ctx context.Context) {
+	routes, _ := n.snapshot()
+
+	var wg sync.WaitGroup
+	for _, r := range routes {
+		for _, m := range r.members() {
+			if m.digest == nil {
+				continue
+			}
+
+			wg.Add(1)
+			go func(r *route) {
+				defer wg.Done()
+				n.flushDigest(ctx, r)
+			}(m)
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,145 @@
+package mio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/phea/mio/pkg/service"
+)
+
+// TestCombine tests the formatting of digests.
+func TestCombine(t *testing.T) {
+	msgs := []Message{
+		{Title: "a", Body: "first", Severity: SeverityInfo},
+		{Title: "b", Severity: SeverityCritical, Priority: PriorityHigh},
+		{Title: "c", Body: "third"},
+	}
+
+	digest := combine(msgs, 2)
+	if digest.Title != "Digest: 3 notifications" {
+		t.Errorf("unexpected title %q", digest.Title)
+	}
+	if want := "- a: first\n- b\n+1 more"; digest.Body != want {
+		t.Errorf("expected body %q, got %q", want, digest.Body)
+	}
+	if digest.Severity != SeverityCritical || digest.Priority != PriorityHigh {
+		t.Errorf("expected the highest severity and priority, got %s and %s", digest.Severity, digest.Priority)
+	}
+}
+
+// TestDigest tests that a route in digest mode sends the accumulated
+// messages once per interval and on Close.
+func TestDigest(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		bodies []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct{ Body string }
+		json.NewDecoder(r.Body).Decode(&payload)
+		mu.Lock()
+		bodies = append(bodies, payload.Body)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	var n Notifier
+	n.Must("json://"+testHost(ts)+"/hook?digest=50ms&digest_max=5", service.SetTLS(false))
+
+	for _, title := range []string{"one", "two"} {
+		report := n.BroadcastContext(context.Background(), Message{Title: title})
+		if !report.Results[0].Digested {
+			t.Errorf("expected the message to be digested")
+		}
+		if report.Err() != nil {
+			t.Errorf("expected no error, got %v", report.Err())
+		}
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	n.BroadcastContext(context.Background(), Message{Title: "three"})
+	if err := n.Close(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"- one\n- two", "- three"}
+	if strings.Join(bodies, "|") != strings.Join(want, "|") {
+		t.Errorf("expected digests %q, got %q", want, bodies)
+	}
+}
+
+// TestDigestParams tests the validation of the digest parameters.
+func TestDigestParams(t *testing.T) {
+	var n Notifier
+	for _, route := range []string{
+		"json://localhost/hook?digest=soon",
+		"json://localhost/hook?digest_max=5",
+		"json://localhost/hook?digest=1m&digest_max=0",
+	} {
+		if err := n.Add(route); err == nil {
+			t.Errorf("expected an error for %s", route)
+		}
+	}
+}
+
+// TestDigestClose tests that messages broadcast to a route in digest mode
+// are either sent by Close or rejected, never lost.
+func TestDigestClose(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	var n Notifier
+	n.Must("json://"+ts.host()+"/hook?digest=1ms&digest_max=100", service.SetTLS(false))
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		digested int
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				res := n.BroadcastContext(context.Background(), Message{Title: "t"}).Results[0]
+				if res.Digested {
+					mu.Lock()
+					digested++
+					mu.Unlock()
+				} else if !errors.Is(res.Err, ErrNotifierClosed) {
+					t.Errorf("expected the message to be digested or rejected, got %v", res.Err)
+				}
+				if res.OK() {
+					t.Errorf("expected a digested message not to be reported as delivered")
+				}
+			}
+		}()
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err := n.Close(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	wg.Wait()
+
+	var sent int
+	for _, body := range ts.bodies() {
+		sent += strings.Count(body, "- t")
+		if i := strings.LastIndex(body, "+"); i >= 0 {
+			more, _ := strconv.Atoi(strings.TrimSuffix(body[i+1:], " more"))
+			sent += more
+		}
+	}
+	if sent != digested {
+		t.Errorf("expected the %d digested messages to be sent, got %d", digested, sent)
+	}
+}
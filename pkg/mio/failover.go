@@ -0,0 +1,119 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package mio
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/phea/mio/pkg/service"
+)
+
+// AddFailover adds a failover chain: the message is sent to the first
+// route and, if it fails, to the next one and so on until a route
+// delivers it. Each route gets its own service instance, configured with
+// the route's query parameters.
+//
+// The chain is a single entry of broadcasts. It is selected by the tags of
+// any of its members, and its result is the one of the member that
+// delivered the message, or of the last member if none did. Adding the
+// message to the digest of a member in digest mode does not deliver it:
+// the message is still sent to the next member.
+func (n *Notifier) AddFailover(routes ...string) error {
+	return n.AddFailoverWith(nil, routes...)
+}
+
+// AddFailoverWith is like AddFailover, with options applied to every route
+// of the chain.
+func (n *Notifier) AddFailoverWith(opts []service.Option, routes ...string) error {
+	if len(routes) == 0 {
+		return fmt.Errorf("failover chain has no routes")
+	}
+
+	chain := &route{scheme: "failover", chain: make([]*route, len(routes))}
+	for i, raw := range routes {
+		r, err := n.newRoute(raw, opts...)
+		if err != nil {
+			return fmt.Errorf("failover route %d: %w", i+1, err)
+		}
+		// a name given in opts names the chain, and its members after it.
+		if r.name != "" {
+			chain.name = r.name
+			r.name += "-" + strconv.Itoa(i+1)
+		}
+		chain.chain[i] = r
+	}
+
+	return n.addChain(chain)
+}
+
+// addChain adds a failover chain once its members are initialized.
+func (n *Notifier) addChain(chain *route) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := n.register(chain, nil); err != nil {
+		return err
+	}
+	n.routes = append(n.routes, chain)
+	return nil
+}
+
+// deliverChain tries the members of the chain in order until one delivers
+// the message. Only the last member writes the message to the outbox, so
+// that the outbox worker does not deliver it again once another member
+// did.
+func (n *Notifier) deliverChain(ctx context.Context, c *route, msg Message, s settings) Result {
+	var tried []Result
+	for i, m := range c.chain {
+		ms := s
+		if i < len(c.chain)-1 {
+			ms.outbox = nil
+		}
+
+		var res Result
+		if m.digest != nil {
+			res = n.addToDigest(m, msg)
+		} else {
+			d := n.newDelivery(m, msg, ms)
+			d.failover = i < len(c.chain)-1
+			res = n.deliver(ctx, d)
+		}
+		tried = append(tried, res)
+		if res.OK() || ctx.Err() != nil {
+			break
+		}
+	}
+
+	res := tried[len(tried)-1]
+	res.Failover = tried
+	return res
+}
+
+// chainRoute describes the chain with the redacted routes of its members.
+func (r *route) chainRoute() string {
+	routes := make([]string, len(r.chain))
+	for i, m := range r.chain {
+		routes[i] = Redact(m.raw)
+	}
+	return strings.Join(routes, " > ")
+}
+
+// chainTags returns the tags of the members of the chain.
+func (r *route) chainTags() []string {
+	var tags []string
+	for _, m := range r.chain {
+		tags = append(tags, m.tags...)
+	}
+	return tags
+}
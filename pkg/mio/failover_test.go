@@ -0,0 +1,146 @@
+package mio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/phea/mio/pkg/service"
+)
+
+// TestFailover tests that the members of a chain are tried in order until
+// one delivers the message.
+func TestFailover(t *testing.T) {
+	var calls [3]int32
+	servers := make([]*httptest.Server, 3)
+	for i, status := range []int{http.StatusBadRequest, http.StatusOK, http.StatusOK} {
+		i, status := i, status
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls[i], 1)
+			w.WriteHeader(status)
+		}))
+		defer servers[i].Close()
+	}
+
+	var n Notifier
+	err := n.AddFailoverWith([]service.Option{service.SetTLS(false)},
+		"json://"+testHost(servers[0])+"/first?tag=ops",
+		"json://"+testHost(servers[1])+"/second",
+		"json://"+testHost(servers[2])+"/third",
+	)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	report := n.BroadcastTo(context.Background(), MustSelector("ops"), Message{Title: "title"})
+	if len(report.Results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(report.Results))
+	}
+	if report.Err() != nil {
+		t.Errorf("expected no error, got %v", report.Err())
+	}
+
+	res := report.Results[0]
+	if !strings.HasSuffix(res.Route, "/second") {
+		t.Errorf("expected the second route to deliver, got %s", res.Route)
+	}
+	if len(res.Failover) != 2 || res.Failover[0].OK() {
+		t.Errorf("expected the first route to fail before the second, got %+v", res.Failover)
+	}
+	if calls != [3]int32{1, 1, 0} {
+		t.Errorf("unexpected calls %v", calls)
+	}
+}
+
+// TestFailoverAllFail tests that a chain fails when none of its members
+// delivers the message.
+func TestFailoverAllFail(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	var n Notifier
+	n.AddFailoverWith([]service.Option{service.SetTLS(false)},
+		"json://"+testHost(ts)+"/a", "json://"+testHost(ts)+"/b")
+
+	report := n.BroadcastContext(context.Background(), Message{Title: "title"})
+	res := report.Results[0]
+	if res.OK() {
+		t.Fatalf("expected the chain to fail")
+	}
+	if len(res.Failover) != 2 || !strings.HasSuffix(res.Route, "/b") {
+		t.Errorf("expected both routes to be tried, got %+v", res.Failover)
+	}
+
+	if err := n.AddFailover(); err == nil {
+		t.Errorf("expected an error for an empty chain")
+	}
+	if err := n.AddFailover("json://localhost/a", "nope://"); err == nil {
+		t.Errorf("expected an error for an unknown route")
+	}
+}
+
+// TestFailoverRateLimitQueue tests that a member of a chain fails over
+// rather than queue messages behind its rate limit.
+func TestFailoverRateLimitQueue(t *testing.T) {
+	var calls [2]int32
+	servers := make([]*httptest.Server, 2)
+	for i := range servers {
+		i := i
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls[i], 1)
+		}))
+		defer servers[i].Close()
+	}
+
+	var n Notifier
+	err := n.AddFailoverWith([]service.Option{service.SetTLS(false)},
+		"json://"+testHost(servers[0])+"/first?rate=1/h&burst=1&limit=queue",
+		"json://"+testHost(servers[1])+"/second",
+	)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer n.Close(context.Background())
+
+	for i := 0; i < 2; i++ {
+		if res := n.BroadcastContext(context.Background(), Message{Title: "title"}).Results[0]; !res.OK() {
+			t.Errorf("expected the chain to deliver, got %+v", res)
+		}
+	}
+	if calls != [2]int32{1, 1} {
+		t.Errorf("expected the second message to fail over, got calls %v", calls)
+	}
+}
+
+// TestFailoverDigest tests that adding the message to the digest of a
+// member does not end the chain.
+func TestFailoverDigest(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	var n Notifier
+	err := n.AddFailoverWith([]service.Option{service.SetTLS(false)},
+		"json://"+ts.host()+"/first?digest=1h",
+		"json://"+ts.host()+"/second",
+	)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	res := n.BroadcastContext(context.Background(), Message{Title: "title"}).Results[0]
+	if !res.OK() || !strings.HasSuffix(res.Route, "/second") {
+		t.Errorf("expected the second member to deliver, got %+v", res)
+	}
+	if len(res.Failover) != 2 || !res.Failover[0].Digested || res.Failover[0].OK() {
+		t.Errorf("expected the first member to digest the message, got %+v", res.Failover)
+	}
+	if ts.hits() != 1 {
+		t.Errorf("expected 1 request, got %d", ts.hits())
+	}
+	n.Close(context.Background())
+}
@@ -0,0 +1,71 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package mio
+
+import (
+	"github.com/phea/mio/pkg/service"
+)
+
+// Logger is a structured logger, implemented by log/slog's Logger.
+type Logger = service.Logger
+
+// SetLogger sets the logger of the Notifier, which is also passed down to
+// the services. The Notifier logs nothing by default; a nil logger turns
+// logging off again.
+func (n *Notifier) SetLogger(l Logger) {
+	n.mu.Lock()
+	n.logger = l
+	n.mu.Unlock()
+}
+
+// log returns a logger forwarding to the Notifier's current logger.
+func (n *Notifier) log() Logger {
+	return notifierLogger{n: n}
+}
+
+// notifierLogger forwards to the logger of the Notifier, so that services
+// pick up a logger set after they were added.
+type notifierLogger struct {
+	n *Notifier
+}
+
+func (l notifierLogger) current() Logger {
+	l.n.mu.RLock()
+	defer l.n.mu.RUnlock()
+	if l.n.logger == nil {
+		return service.NopLogger{}
+	}
+	return l.n.logger
+}
+
+func (l notifierLogger) Debug(msg string, args ...interface{}) { l.current().Debug(msg, args...) }
+func (l notifierLogger) Info(msg string, args ...interface{})  { l.current().Info(msg, args...) }
+func (l notifierLogger) Warn(msg string, args ...interface{})  { l.current().Warn(msg, args...) }
+func (l notifierLogger) Error(msg string, args ...interface{}) { l.current().Error(msg, args...) }
+
+// record logs the outcome of a delivery and adds it to the metrics.
+func (n *Notifier) record(res Result) {
+	n.metrics.observe(res)
+
+	args := []interface{}{
+		"scheme", res.Scheme,
+		"route", res.Route,
+		"name", res.Name,
+		"latency", res.Duration,
+		"attempts", res.Attempts,
+	}
+	if res.OK() {
+		n.log().Debug("message delivered", args...)
+		return
+	}
+	n.log().Warn("message delivery failed", append(args, "error", res.Err)...)
+}
@@ -0,0 +1,68 @@
+package mio
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/phea/mio/pkg/service"
+)
+
+// testLogger records the messages logged, with their level and args.
+type testLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *testLogger) log(level, msg string, args []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf("%s %s %v", level, msg, args))
+}
+
+func (l *testLogger) Debug(msg string, args ...interface{}) { l.log("DEBUG", msg, args) }
+func (l *testLogger) Info(msg string, args ...interface{})  { l.log("INFO", msg, args) }
+func (l *testLogger) Warn(msg string, args ...interface{})  { l.log("WARN", msg, args) }
+func (l *testLogger) Error(msg string, args ...interface{}) { l.log("ERROR", msg, args) }
+
+// TestLogger tests that deliveries are logged with the redacted route,
+// including by the services, once a logger is set.
+func TestLogger(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+
+	var n Notifier
+	n.Must("json://user:secret@"+testHost(ts)+"/ok", service.SetTLS(false))
+	n.Must("json://"+testHost(ts)+"/fail", service.SetTLS(false))
+
+	// nothing is logged by default.
+	n.Broadcast("title", "body")
+
+	logger := &testLogger{}
+	n.SetLogger(logger)
+	n.Broadcast("title", "body")
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	logs := strings.Join(logger.lines, "\n")
+	for _, want := range []string{
+		"DEBUG request sent [scheme json status 200]",
+		"DEBUG message delivered [scheme json route json://user:xxxxx@",
+		"WARN message delivery failed [scheme json route json://localhost",
+		"INFO broadcast [success 1 failed 1]",
+	} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("expected %q in logs:\n%s", want, logs)
+		}
+	}
+	if strings.Contains(logs, "secret") {
+		t.Errorf("expected the password to be redacted:\n%s", logs)
+	}
+}
@@ -0,0 +1,207 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package mio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// LatencyBuckets are the upper bounds, in seconds, of the buckets of the
+// latency histograms.
+var LatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram is a distribution of observed values.
+type Histogram struct {
+	// Buckets are the upper bounds of the buckets, Counts the number of
+	// values observed in each one, not cumulated.
+	Buckets []float64
+	Counts  []uint64
+	Sum     float64
+	Count   uint64
+}
+
+func (h *Histogram) observe(v float64) {
+	if h.Counts == nil {
+		h.Buckets = LatencyBuckets
+		h.Counts = make([]uint64, len(h.Buckets))
+	}
+	for i, b := range h.Buckets {
+		if v <= b {
+			h.Counts[i]++
+			break
+		}
+	}
+	h.Sum += v
+	h.Count++
+}
+
+// Series holds the metrics of a route.
+type Series struct {
+	Scheme string
+	// Route is the name of the route, see WithName.
+	Route string
+	// Sends is the number of deliveries, Failures the number of them that
+	// failed, and Retries the number of attempts made after the first.
+	Sends    uint64
+	Failures uint64
+	Retries  uint64
+	// Latency is the distribution of the delivery durations in seconds,
+	// including retries.
+	Latency Histogram
+}
+
+type seriesKey struct {
+	scheme, route string
+}
+
+// Metrics collects the delivery metrics of a Notifier. It is an
+// http.Handler serving them in the Prometheus text format.
+type Metrics struct {
+	mu     sync.Mutex
+	series map[seriesKey]*Series
+}
+
+// Metrics returns the delivery metrics of the Notifier.
+func (n *Notifier) Metrics() *Metrics {
+	return &n.metrics
+}
+
+// observe records the outcome of a delivery.
+func (m *Metrics) observe(res Result) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := seriesKey{scheme: res.Scheme, route: res.Name}
+	s, ok := m.series[key]
+	if !ok {
+		if m.series == nil {
+			m.series = make(map[seriesKey]*Series)
+		}
+		s = &Series{Scheme: res.Scheme, Route: res.Name}
+		m.series[key] = s
+	}
+
+	s.Sends++
+	if !res.OK() {
+		s.Failures++
+	}
+	if res.Attempts > 1 {
+		s.Retries += uint64(res.Attempts - 1)
+	}
+	s.Latency.observe(res.Duration.Seconds())
+}
+
+// Series returns a copy of the metrics of every route, sorted by scheme
+// and route.
+func (m *Metrics) Series() []Series {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	series := make([]Series, 0, len(m.series))
+	for _, s := range m.series {
+		c := *s
+		c.Latency.Counts = append([]uint64(nil), s.Latency.Counts...)
+		series = append(series, c)
+	}
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].Scheme != series[j].Scheme {
+			return series[i].Scheme < series[j].Scheme
+		}
+		return series[i].Route < series[j].Route
+	})
+	return series
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	series := m.Series()
+	bw := bufio.NewWriter(w)
+	cw := &countWriter{w: bw}
+
+	counters := []struct {
+		name, help string
+		value      func(Series) uint64
+	}{
+		{"mio_sends_total", "Deliveries of messages to routes.", func(s Series) uint64 { return s.Sends }},
+		{"mio_failures_total", "Deliveries of messages to routes that failed.", func(s Series) uint64 { return s.Failures }},
+		{"mio_retries_total", "Attempts made after the first one of a delivery.", func(s Series) uint64 { return s.Retries }},
+	}
+	for _, c := range counters {
+		fmt.Fprintf(cw, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+		for _, s := range series {
+			fmt.Fprintf(cw, "%s{%s} %d\n", c.name, labels(s), c.value(s))
+		}
+	}
+
+	const latency = "mio_send_duration_seconds"
+	fmt.Fprintf(cw, "# HELP %s Duration of the deliveries, including retries.\n# TYPE %s histogram\n", latency, latency)
+	for _, s := range series {
+		var cumulative uint64
+		for i, b := range s.Latency.Buckets {
+			cumulative += s.Latency.Counts[i]
+			fmt.Fprintf(cw, "%s_bucket{%s,le=%q} %d\n", latency, labels(s), formatFloat(b), cumulative)
+		}
+		fmt.Fprintf(cw, "%s_bucket{%s,le=\"+Inf\"} %d\n", latency, labels(s), s.Latency.Count)
+		fmt.Fprintf(cw, "%s_sum{%s} %s\n", latency, labels(s), formatFloat(s.Latency.Sum))
+		fmt.Fprintf(cw, "%s_count{%s} %d\n", latency, labels(s), s.Latency.Count)
+	}
+
+	if err := bw.Flush(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, cw.err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// labels formats the labels of the series.
+func labels(s Series) string {
+	return `route="` + escapeLabel(s.Route) + `",scheme="` + escapeLabel(s.Scheme) + `"`
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// countWriter counts the bytes written and keeps the first error.
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
@@ -0,0 +1,78 @@
+package mio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/phea/mio/pkg/service"
+)
+
+// TestMetrics tests that deliveries are counted by route.
+func TestMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	var n Notifier
+	n.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond})
+	n.Must("json://"+testHost(ts)+"/ok", service.SetTLS(false))
+	n.Must("json://"+testHost(ts)+"/fail", service.SetTLS(false), WithName("pager"))
+
+	for i := 0; i < 2; i++ {
+		n.BroadcastContext(context.Background(), Message{Title: "title"})
+	}
+
+	series := n.Metrics().Series()
+	if len(series) != 2 {
+		t.Fatalf("expected 2 series, got %d", len(series))
+	}
+
+	ok, pager := series[0], series[1]
+	if ok.Route != "json-1" || ok.Sends != 2 || ok.Failures != 0 || ok.Retries != 0 {
+		t.Errorf("unexpected series %+v", ok)
+	}
+	if pager.Route != "pager" || pager.Sends != 2 || pager.Failures != 2 || pager.Retries != 2 {
+		t.Errorf("unexpected series %+v", pager)
+	}
+	if ok.Latency.Count != 2 {
+		t.Errorf("expected 2 latency observations, got %d", ok.Latency.Count)
+	}
+}
+
+// TestMetricsHandler tests the Prometheus text format.
+func TestMetricsHandler(t *testing.T) {
+	var m Metrics
+	m.observe(Result{Name: `a"b`, Scheme: "json", Attempts: 3, Duration: 20 * time.Millisecond})
+	m.observe(Result{Name: `a"b`, Scheme: "json", Attempts: 1, Duration: 2 * time.Second, Err: context.Canceled})
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE mio_sends_total counter\n",
+		`mio_sends_total{route="a\"b",scheme="json"} 2` + "\n",
+		`mio_failures_total{route="a\"b",scheme="json"} 1` + "\n",
+		`mio_retries_total{route="a\"b",scheme="json"} 2` + "\n",
+		"# TYPE mio_send_duration_seconds histogram\n",
+		`mio_send_duration_seconds_bucket{route="a\"b",scheme="json",le="0.025"} 1` + "\n",
+		`mio_send_duration_seconds_bucket{route="a\"b",scheme="json",le="2.5"} 2` + "\n",
+		`mio_send_duration_seconds_bucket{route="a\"b",scheme="json",le="+Inf"} 2` + "\n",
+		`mio_send_duration_seconds_sum{route="a\"b",scheme="json"} 2.02` + "\n",
+		`mio_send_duration_seconds_count{route="a\"b",scheme="json"} 2` + "\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in:\n%s", want, body)
+		}
+	}
+}
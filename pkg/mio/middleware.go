@@ -0,0 +1,102 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package mio
+
+import (
+	"context"
+
+	"github.com/phea/mio/pkg/service"
+)
+
+// SendFunc sends a message to a route.
+type SendFunc func(ctx context.Context, msg Message) error
+
+// Middleware wraps the sending of messages to routes. It may change the
+// message, e.g. to add the hostname, record it, or block it by returning
+// an error without calling next.
+//
+// Middlewares run once per delivery, around the retries and rate limits of
+// the route: next makes every attempt the route's policy allows.
+type Middleware func(next SendFunc) SendFunc
+
+// Use adds middlewares wrapping the sending of messages to every route.
+// They run in the order they are added, before the middlewares of the
+// routes.
+func (n *Notifier) Use(mw ...Middleware) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	middleware := make([]Middleware, 0, len(n.middleware)+len(mw))
+	middleware = append(middleware, n.middleware...)
+	n.middleware = append(middleware, mw...)
+}
+
+// SetTransport makes the sends to every route call fn instead of the
+// route's service, once every middleware, retry and rate limit applied. The
+// route is available to fn through RouteFrom. A nil fn restores the
+// services. It is meant for tests, see the miotest package.
+func (n *Notifier) SetTransport(fn SendFunc) {
+	n.mu.Lock()
+	n.transport = fn
+	n.mu.Unlock()
+}
+
+// WithMiddleware adds middlewares wrapping the sending of messages to the
+// route. They run after the Notifier's middlewares.
+func WithMiddleware(mw ...Middleware) service.Option {
+	return func(s service.Service) {
+		s.SetOption(optMiddleware, mw)
+	}
+}
+
+// wrap returns send wrapped in the middlewares, the first one being the
+// outermost.
+func wrap(send SendFunc, mw []Middleware) SendFunc {
+	for i := len(mw) - 1; i >= 0; i-- {
+		send = mw[i](send)
+	}
+	return send
+}
+
+type resultKey struct{}
+
+// withResult returns a context carrying the result of a delivery, for the
+// built-in middlewares to fill in.
+func withResult(ctx context.Context, res *Result) context.Context {
+	return context.WithValue(ctx, resultKey{}, res)
+}
+
+type routeKey struct{}
+
+// withRoute returns a context carrying the route of a delivery.
+func withRoute(ctx context.Context, r *route) context.Context {
+	return context.WithValue(ctx, routeKey{}, r)
+}
+
+// RouteFrom returns the description of the route a message is being sent
+// to, for the middlewares and services to tell the routes apart. It
+// returns false if ctx does not belong to a delivery.
+func RouteFrom(ctx context.Context) (RouteInfo, bool) {
+	if r, ok := ctx.Value(routeKey{}).(*route); ok {
+		return r.info(), true
+	}
+	return RouteInfo{}, false
+}
+
+// resultFrom returns the result carried by the context, or a throwaway
+// one if there is none.
+func resultFrom(ctx context.Context) *Result {
+	if res, ok := ctx.Value(resultKey{}).(*Result); ok {
+		return res
+	}
+	return &Result{}
+}
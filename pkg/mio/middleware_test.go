@@ -0,0 +1,65 @@
+package mio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/phea/mio/pkg/service"
+)
+
+// TestMiddleware tests that the Notifier's and routes' middlewares wrap
+// the sends in order, and that they can block a message.
+func TestMiddleware(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		titles []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct{ Title string }
+		json.NewDecoder(r.Body).Decode(&payload)
+		mu.Lock()
+		titles = append(titles, payload.Title)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	prefix := func(p string) Middleware {
+		return func(next SendFunc) SendFunc {
+			return func(ctx context.Context, msg Message) error {
+				msg.Title = p + msg.Title
+				return next(ctx, msg)
+			}
+		}
+	}
+	errBlocked := errors.New("blocked")
+	block := func(next SendFunc) SendFunc {
+		return func(ctx context.Context, msg Message) error {
+			return errBlocked
+		}
+	}
+
+	var n Notifier
+	n.Use(prefix("[prod] "))
+	n.Must("json://"+testHost(ts)+"/a", service.SetTLS(false), WithMiddleware(prefix("[a] ")))
+	n.Must("json://"+testHost(ts)+"/b", service.SetTLS(false), WithMiddleware(block))
+
+	report := n.BroadcastContext(context.Background(), Message{Title: "title"})
+	if err := report.Results[0].Err; err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if res := report.Results[1]; !errors.Is(res.Err, errBlocked) || res.Attempts != 0 {
+		t.Errorf("expected the message to be blocked, got %v after %d attempts", res.Err, res.Attempts)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(titles, "|") != "[a] [prod] title" {
+		t.Errorf("unexpected titles %q", titles)
+	}
+}
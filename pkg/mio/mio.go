@@ -12,9 +12,10 @@
 package mio
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"sync"
+	"time"
 
 	"github.com/phea/mio/internal/matcher"
 	"github.com/phea/mio/pkg/service"
@@ -24,61 +25,174 @@ var (
 	ErrServiceNotFound = fmt.Errorf("route does not match any service")
 )
 
-type serviceMatch struct {
-	service service.Service
-	matcher *matcher.Matcher
-}
+// Message is the notification delivered to the routes.
+type Message = service.Message
 
-var serviceMatchers = []serviceMatch{}
+// Priority and Severity of a Message.
+type (
+	Priority = service.Priority
+	Severity = service.Severity
+)
 
-func init() {
-	// Register all services here.
-	for _, spec := range service.Specs() {
-		addMatcher(spec)
-	}
-}
+const (
+	PriorityLow    = service.PriorityLow
+	PriorityNormal = service.PriorityNormal
+	PriorityHigh   = service.PriorityHigh
+	PriorityUrgent = service.PriorityUrgent
 
-// addMatcher takes an array of service specs and adds
-// them to the service matchers array.
-func addMatcher(spec service.Spec) {
-	for _, t := range spec.Template {
-		serviceMatchers = append(serviceMatchers, serviceMatch{
-			service: spec.Init(),
-			matcher: matcher.New(t),
-		})
-	}
+	SeverityDebug    = service.SeverityDebug
+	SeverityInfo     = service.SeverityInfo
+	SeverityWarning  = service.SeverityWarning
+	SeverityError    = service.SeverityError
+	SeverityCritical = service.SeverityCritical
+)
+
+// route is a service bound to the route it was added with.
+type route struct {
+	raw  string
+	vars map[string]string // secrets masked
+	// secrets are the values of the placeholders of the route, masked in
+	// the errors of its service.
+	secrets []string
+	name    string
+	scheme  string
+	svc     service.Service
+	tags    []string
+	retry   *RetryPolicy // overrides the notifier's policy when set
+	limit   *limiter
+	digest  *digest
+	// middleware wraps the sends to the route, after the Notifier's.
+	middleware []Middleware
+	// chain holds the members of a failover chain, tried in order. The
+	// other fields are unset for chains.
+	chain []*route
+
+	mu            sync.Mutex
+	breakerPolicy *BreakerPolicy // overrides the notifier's policy when set
+	breaker       *breaker       // created on first use
 }
 
-// Notifier is responsible for sending messages.
+// Notifier is responsible for sending messages. It is safe for
+// concurrent use.
 type Notifier struct {
-	svcs []service.Service
+	mu      sync.RWMutex
+	routes  []*route
+	retry   RetryPolicy
+	outbox  *Outbox
+	limits  map[string]*limiter // by scheme
+	breaker BreakerPolicy
+	rules   []rule
+	dedup   *DedupPolicy
+
+	middleware []Middleware
+	transport  SendFunc // replaces the services if set
+	logger     Logger
+	resolver   SecretResolver
+	async      *asyncQueue
+	sem        semaphore            // bounds the routes delivered to at once
+	schemeSems map[string]semaphore // bounds the sends by scheme
+	metrics    Metrics
+	seq        map[string]int // routes added by scheme, to name them
+	dryRun     bool
+	registry   *Registry // the default registry if nil
+
+	// stop is closed by Close to stop the background workers.
+	stop    chan struct{}
+	closed  bool
+	workers sync.WaitGroup
 }
 
 // Add matches the route to a service and adds it to the notifier.
-// NOTE: This function assumes templates are ordered least to most specific.
-func (n *Notifier) Add(route string, opts ...service.Option) error {
-	var svc service.Service
-	for _, m := range serviceMatchers {
-		if m.matcher.IsMatch(route) {
-			vars, err := m.matcher.Vars(route)
-			if err != nil {
-				return err
-			}
+// Every route gets its own service instance, so the same scheme can be
+// added any number of times.
+func (n *Notifier) Add(rawRoute string, opts ...service.Option) error {
+	r, err := n.newRoute(rawRoute, opts...)
+	if err != nil {
+		return err
+	}
 
-			err = m.service.Init(route, vars, opts...)
-			if err != nil {
-				return err
-			}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := n.register(r, nil); err != nil {
+		return err
+	}
+	n.routes = append(n.routes, r)
+	return nil
+}
+
+// newRoute resolves the placeholders of the route, matches it to a
+// service and initializes a new instance of it, logging to the Notifier's
+// logger unless opts set another one. The secrets the placeholders stand
+// for are masked in the errors and the route's variables.
+func (n *Notifier) newRoute(rawRoute string, opts ...service.Option) (*route, error) {
+	resolved, secrets, err := expandSecrets(rawRoute, n.secretResolver())
+	if err != nil {
+		return nil, err
+	}
+
+	r, secrets, err := n.initRoute(resolved, secrets, opts)
+	if err != nil {
+		return nil, redactError(err, secrets)
+	}
+	r.raw = rawRoute
+	return r, nil
+}
+
+// initRoute initializes the service of a route whose placeholders are
+// resolved. It returns the secrets of the route: the values of its
+// placeholders and of its variables holding secrets.
+func (n *Notifier) initRoute(rawRoute string, secrets []string, opts []service.Option) (*route, []string, error) {
+	reg := n.services()
+	m, ok := reg.match(rawRoute)
+	if !ok {
+		return nil, secrets, reg.diagnose(rawRoute, ErrServiceNotFound)
+	}
 
-			svc = m.service
+	vars, err := m.matcher.Vars(rawRoute)
+	if err != nil {
+		return nil, secrets, reg.diagnose(rawRoute, err)
+	}
+	for k, v := range vars {
+		if isSecretVar(k) && v != "" {
+			secrets = append(secrets, v)
 		}
 	}
-	if svc == nil {
-		return ErrServiceNotFound
+
+	cfg, svcOpts := newRouteConfig(opts)
+	if err := cfg.parseParams(vars); err != nil {
+		return nil, secrets, err
 	}
 
-	n.svcs = append(n.svcs, svc)
-	return nil
+	masked := maskVars(vars)
+	for k, v := range masked {
+		masked[k] = maskSecrets(v, secrets)
+	}
+	svcOpts = append([]service.Option{service.SetLogger(n.log())}, svcOpts...)
+	svc := m.newService()
+	err = svc.Init(matcher.StripQuery(rawRoute, routeParams...), vars, svcOpts...)
+	if err != nil {
+		return nil, secrets, err
+	}
+
+	r := &route{
+		vars:    masked,
+		secrets: secrets,
+		name:    cfg.name,
+		scheme:  m.matcher.Scheme(),
+		svc:     svc,
+		tags:    cfg.tags,
+		retry:   cfg.retry,
+
+		middleware: cfg.middleware,
+	}
+	if cfg.limit != nil {
+		r.limit = newLimiter(*cfg.limit)
+	}
+	r.breakerPolicy = cfg.breaker
+	if cfg.digest != nil {
+		r.digest = newDigest(*cfg.digest)
+	}
+	return r, secrets, nil
 }
 
 // Must is a helper function that calls Add and panics if an error occurs.
@@ -88,41 +202,244 @@ func (n *Notifier) Must(route string, opts ...service.Option) {
 	}
 }
 
-type broadCastResult struct {
-	err error
-}
-
 // Broadcast asynchronously sends a message to all registered services.
 func (n *Notifier) Broadcast(title, body string) {
-	results := make(chan broadCastResult, len(n.svcs))
+	report := n.BroadcastContext(context.Background(), Message{Title: title, Body: body})
+	n.log().Info("broadcast",
+		"success", len(report.Sent())-len(report.Failed()), "failed", len(report.Failed()))
+}
+
+// BroadcastContext sends msg to all registered services concurrently and
+// waits for them to finish. The context is passed down to every service so
+// cancelling it, or letting its deadline pass, aborts sends in flight.
+// The returned report holds one result per route, in the order the routes
+// were added. Messages without a timestamp are stamped with the current
+// time.
+func (n *Notifier) BroadcastContext(ctx context.Context, msg Message) Report {
+	return n.BroadcastTo(ctx, nil, msg)
+}
+
+// BroadcastTo is like BroadcastContext but only sends msg to the routes
+// whose tags match the selector. A nil selector matches every route. The
+// report only holds the results of the selected routes, including the
+// ones skipped by the Notifier's rules. Duplicates suppressed by the
+// Notifier's dedup policy are not sent at all.
+func (n *Notifier) BroadcastTo(ctx context.Context, sel Selector, msg Message) Report {
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+
+	n.mu.RLock()
+	dedup := n.dedup
+	if n.dryRun {
+		dedup = nil
+	}
+	n.mu.RUnlock()
+
+	if dedup != nil {
+		// a failing store lets the message through rather than losing it.
+		dup, followUps, err := dedup.check(sel, msg, time.Now())
+		n.sendFollowUps(ctx, dedup, followUps)
+		if err == nil && dup {
+			return Report{Suppressed: true}
+		}
+	}
+
+	return n.broadcast(ctx, sel, msg)
+}
+
+// broadcast sends msg to the routes matching the selector and the rules.
+func (n *Notifier) broadcast(ctx context.Context, sel Selector, msg Message) Report {
+	all, s := n.snapshot()
+	var routes []*route
+	for _, r := range all {
+		if sel == nil || sel.Match(r.selectorTags()) {
+			routes = append(routes, r)
+		}
+	}
+
+	report := Report{Results: make([]Result, len(routes))}
 	var wg sync.WaitGroup
-	wg.Add(len(n.svcs))
-	for _, svc := range n.svcs {
-		go func(svc service.Service) {
-			err := svc.Send(title, body)
-			results <- broadCastResult{
-				// serviceName: svc.Route(),
-				err: err,
+	for i, r := range routes {
+		ok, rule, decision := decide(s.rules, msg, r.selectorTags())
+		if !ok {
+			report.Results[i] = r.skipped(decision)
+			continue
+		}
+
+		if s.dryRun {
+			res := n.previewRoute(ctx, r, msg, s)
+			res.Rule, res.Decision = rule, decision
+			report.Results[i] = res
+			continue
+		}
+
+		if r.digest != nil {
+			res := n.addToDigest(r, msg)
+			res.Rule, res.Decision = rule, decision
+			report.Results[i] = res
+			continue
+		}
+
+		if s.sem != nil {
+			if err := s.sem.acquire(ctx); err != nil {
+				res := r.result()
+				res.Err, res.Rule, res.Decision = err, rule, decision
+				report.Results[i] = res
+				continue
 			}
-			wg.Done()
-		}(svc)
+		}
+
+		wg.Add(1)
+		go func(i int, r *route) {
+			defer wg.Done()
+			if s.sem != nil {
+				defer s.sem.release()
+			}
+			var res Result
+			if r.chain != nil {
+				res = n.deliverChain(ctx, r, msg, s)
+			} else {
+				res = n.deliver(ctx, n.newDelivery(r, msg, s))
+			}
+			res.Rule, res.Decision = rule, decision
+			report.Results[i] = res
+		}(i, r)
 	}
 
 	// wait for all goroutines to finish
 	wg.Wait()
-	close(results)
+	return report
+}
 
-	// we can do something with the results here
-	// for example, we can print the results
-	successN, failN := 0, 0
+// selectorTags returns the tags of the route along with its implicit
+// scheme tag. A failover chain has the tags of all its members.
+func (r *route) selectorTags() []string {
+	if r.chain != nil {
+		var tags []string
+		for _, m := range r.chain {
+			tags = append(tags, m.selectorTags()...)
+		}
+		return tags
+	}
+	return append(r.tags[:len(r.tags):len(r.tags)], "scheme:"+r.scheme)
+}
 
-	for result := range results {
-		if result.err != nil {
-			failN++
-		} else {
-			successN++
+// members returns the routes of a failover chain, or the route itself.
+func (r *route) members() []*route {
+	if r.chain != nil {
+		return r.chain
+	}
+	return []*route{r}
+}
+
+// result returns a result identifying the route.
+func (r *route) result() Result {
+	if r.chain != nil {
+		return Result{Route: r.chainRoute(), Name: r.name, Scheme: r.scheme, Tags: r.chainTags()}
+	}
+	return Result{
+		Route:  Redact(r.raw),
+		Name:   r.name,
+		Scheme: r.scheme,
+		Tags:   r.tags,
+	}
+}
+
+// skipped returns the result of a route the message was not sent to.
+func (r *route) skipped(decision string) Result {
+	res := r.result()
+	res.Skipped, res.Decision = true, decision
+	return res
+}
+
+// goWorker runs fn in a background goroutine. The channel passed to fn is
+// closed when the Notifier is closed, and Close waits for fn to return.
+// It reports whether fn was started: once Close stopped the workers, no
+// new ones are, so that Close does not miss them.
+func (n *Notifier) goWorker(fn func(stop <-chan struct{})) bool {
+	n.mu.Lock()
+	if n.stop == nil {
+		n.stop = make(chan struct{})
+	}
+	stop := n.stop
+	select {
+	case <-stop:
+		n.mu.Unlock()
+		return false
+	default:
+	}
+	n.workers.Add(1)
+	n.mu.Unlock()
+
+	go func() {
+		defer n.workers.Done()
+		fn(stop)
+	}()
+	return true
+}
+
+// stopContext returns a context that is cancelled once stop is closed.
+func stopContext(stop <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// Close waits for the messages queued by Notify to be sent and sends the
+// pending digests and dedup follow-ups, see DedupPolicy.FlushOnClose, then stops the background workers
+// of the Notifier and waits for them to finish, or for ctx to be done.
+// Queued messages are discarded if ctx is done first. The outbox, if any,
+// is closed once the workers are done. Messages broadcast to routes in
+// digest mode once Close started fail with ErrNotifierClosed.
+func (n *Notifier) Close(ctx context.Context) error {
+	n.mu.Lock()
+	async := n.async
+	n.closed = true
+	n.mu.Unlock()
+
+	if async != nil {
+		async.flush(ctx)
+		if discarded := async.close(); discarded > 0 {
+			n.log().Warn("notifier closed, discarded queued messages", "count", discarded)
 		}
 	}
+	n.flushDigests(ctx)
+	n.flushFollowUps(ctx)
+
+	n.mu.Lock()
+	if n.stop == nil {
+		n.stop = make(chan struct{})
+	}
+	select {
+	case <-n.stop:
+	default:
+		close(n.stop)
+	}
+	outbox := n.outbox
+	n.outbox = nil
+	n.mu.Unlock()
 
-	log.Printf("Broadcast: %d success, %d failed\n", successN, failN)
+	done := make(chan struct{})
+	go func() {
+		n.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if outbox != nil {
+		return outbox.Close()
+	}
+	return nil
 }
@@ -0,0 +1,232 @@
+package mio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/phea/mio/pkg/service"
+)
+
+// testHost returns the host:port of the test server using the localhost
+// name, since the route templates only match named hosts.
+func testHost(ts *httptest.Server) string {
+	return strings.Replace(strings.TrimPrefix(ts.URL, "http://"), "127.0.0.1", "localhost", 1)
+}
+
+// testServer is a test server recording the requests it received.
+type testServer struct {
+	*httptest.Server
+
+	// failures is the number of requests answered with the status code
+	// fail, the others succeed.
+	failures int
+	fail     int
+	// delay is the time taken to handle every request.
+	delay time.Duration
+
+	mu       sync.Mutex
+	received int
+	messages []string
+	// current and max are the numbers of requests being handled, now and
+	// at most.
+	current, max int
+}
+
+// serverOption configures a testServer.
+type serverOption func(*testServer)
+
+// withFailures makes the server answer the first n requests with the
+// status code.
+func withFailures(n, code int) serverOption {
+	return func(s *testServer) {
+		s.failures, s.fail = n, code
+	}
+}
+
+// withDelay makes the server take d to handle every request.
+func withDelay(d time.Duration) serverOption {
+	return func(s *testServer) {
+		s.delay = d
+	}
+}
+
+// newTestServer starts a test server, to be closed by the caller.
+func newTestServer(opts ...serverOption) *testServer {
+	s := &testServer{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var payload map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&payload)
+
+	s.mu.Lock()
+	s.received++
+	failed := s.received <= s.failures
+	if body, ok := payload["body"].(string); ok && !failed {
+		s.messages = append(s.messages, body)
+	}
+	s.current++
+	if s.current > s.max {
+		s.max = s.current
+	}
+	s.mu.Unlock()
+
+	time.Sleep(s.delay)
+
+	s.mu.Lock()
+	s.current--
+	s.mu.Unlock()
+	if failed {
+		w.WriteHeader(s.fail)
+	}
+}
+
+// host returns the host:port of the server, see testHost.
+func (s *testServer) host() string {
+	return testHost(s.Server)
+}
+
+// hits returns the number of requests received.
+func (s *testServer) hits() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.received
+}
+
+// bodies returns the bodies of the JSON messages delivered, in order.
+func (s *testServer) bodies() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages...)
+}
+
+// maxConcurrent returns the highest number of requests handled at once.
+func (s *testServer) maxConcurrent() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.max
+}
+
+// TestBroadcastContextReport tests that the report holds one result per
+// route with the route redacted.
+func TestBroadcastContextReport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	var n Notifier
+	n.Must("json://user:secret@"+testHost(ts)+"/hook", service.SetTLS(false))
+
+	report := n.BroadcastContext(context.Background(), Message{Title: "title", Body: "body"})
+	if len(report.Results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(report.Results))
+	}
+
+	res := report.Results[0]
+	if res.Err != nil {
+		t.Errorf("expected no error, got %v", res.Err)
+	}
+	if res.Scheme != "json" {
+		t.Errorf("expected scheme to be json, got %s", res.Scheme)
+	}
+	if strings.Contains(res.Route, "secret") {
+		t.Errorf("expected password to be redacted, got %s", res.Route)
+	}
+	if res.Attempts != 1 {
+		t.Errorf("expected 1 attempt, got %d", res.Attempts)
+	}
+	if report.Err() != nil {
+		t.Errorf("expected no report error, got %v", report.Err())
+	}
+}
+
+// TestBroadcastContextCancel tests that the context deadline is propagated
+// to the services.
+func TestBroadcastContextCancel(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	var n Notifier
+	n.Must("json://"+testHost(ts), service.SetTLS(false))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	report := n.BroadcastContext(ctx, Message{Title: "title", Body: "body"})
+	if len(report.Failed()) != 1 {
+		t.Fatalf("expected 1 failed route, got %d", len(report.Failed()))
+	}
+	if !errors.Is(report.Results[0].Err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", report.Results[0].Err)
+	}
+}
+
+// TestAddSameScheme tests that routes sharing a scheme each get their own
+// service instance.
+func TestAddSameScheme(t *testing.T) {
+	hits := make([]int, 2)
+	var servers []*httptest.Server
+	for i := range hits {
+		i := i
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[i]++
+		}))
+		defer ts.Close()
+		servers = append(servers, ts)
+	}
+
+	var n1, n2 Notifier
+	n1.Must("json://"+testHost(servers[0]), service.SetTLS(false))
+	n1.Must("json://"+testHost(servers[1]), service.SetTLS(false))
+	n2.Must("json://"+testHost(servers[1]), service.SetTLS(false))
+
+	if err := n1.BroadcastContext(context.Background(), Message{Title: "t"}).Err(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if hits[0] != 1 || hits[1] != 1 {
+		t.Errorf("expected each server to be hit once, got %v", hits)
+	}
+}
+
+// TestBroadcastMessage tests that the message fields reach the services and
+// that messages are timestamped.
+func TestBroadcastMessage(t *testing.T) {
+	var got map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer ts.Close()
+
+	var n Notifier
+	n.Must("json://"+testHost(ts), service.SetTLS(false))
+
+	msg := Message{Title: "t", Severity: SeverityCritical, Tags: []string{"db"}}
+	if err := n.BroadcastContext(context.Background(), msg).Err(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if got["severity"] != "critical" {
+		t.Errorf("expected severity to be critical, got %v", got["severity"])
+	}
+	if tags, _ := got["tags"].([]interface{}); len(tags) != 1 || tags[0] != "db" {
+		t.Errorf("expected tags to be [db], got %v", got["tags"])
+	}
+	if got["timestamp"] == nil {
+		t.Errorf("expected the message to be timestamped")
+	}
+}
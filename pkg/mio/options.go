@@ -0,0 +1,317 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package mio
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/phea/mio/pkg/service"
+)
+
+// Keys of the route options handled by the Notifier rather than by the
+// services.
+const (
+	optRetry   = "mio.retry"
+	optLimit   = "mio.limit"
+	optBreaker = "mio.breaker"
+	optTags    = "mio.tags"
+	optDigest  = "mio.digest"
+	optName    = "mio.name"
+
+	optMiddleware = "mio.middleware"
+	optParams     = "mio.params"
+)
+
+// Query parameters handled by the Notifier. They are removed from the
+// route before it is passed to the service.
+var routeParams = []string{"retries", "backoff", "rate", "burst", "limit", "tag", "digest", "digest_max"}
+
+// WithRetry overrides the Notifier's retry policy for the route.
+func WithRetry(p RetryPolicy) service.Option {
+	return func(s service.Service) {
+		s.SetOption(optRetry, p)
+	}
+}
+
+// WithRateLimit rate limits the route.
+func WithRateLimit(l RateLimit) service.Option {
+	return func(s service.Service) {
+		s.SetOption(optLimit, l)
+	}
+}
+
+// WithBreaker overrides the Notifier's circuit breaker policy for the
+// route.
+func WithBreaker(p BreakerPolicy) service.Option {
+	return func(s service.Service) {
+		s.SetOption(optBreaker, p)
+	}
+}
+
+// WithTags tags the route so that it can be selected by BroadcastTo. Tags
+// can also be given with the tag query parameter, e.g. "?tag=oncall,db".
+func WithTags(tags ...string) service.Option {
+	return func(s service.Service) {
+		s.SetOption(optTags, tags)
+	}
+}
+
+// WithDigest puts the route in digest mode: messages are accumulated and
+// sent as a single digest per interval. Digests can also be set with the
+// digest and digest_max query parameters, e.g. "?digest=5m&digest_max=20".
+func WithDigest(p DigestPolicy) service.Option {
+	return func(s service.Service) {
+		s.SetOption(optDigest, p)
+	}
+}
+
+// WithName names the route. The name is the ID of the route, it must be
+// unique within the Notifier, labels the route's metrics and identifies it
+// in reports. Unnamed routes are named after their scheme and the order
+// they were added in, e.g. "json-2".
+func WithName(name string) service.Option {
+	return func(s service.Service) {
+		s.SetOption(optName, name)
+	}
+}
+
+// withParams sets route parameters as if they were given in the query of
+// the route.
+func withParams(params map[string]string) service.Option {
+	return func(s service.Service) {
+		s.SetOption(optParams, params)
+	}
+}
+
+// routeConfig collects the route level settings handled by the Notifier.
+// It implements service.Service only so that options can be applied to
+// it, it is never sent to.
+type routeConfig struct {
+	retry   *RetryPolicy
+	limit   *RateLimit
+	breaker *BreakerPolicy
+	tags    []string
+	digest  *DigestPolicy
+	name    string
+
+	middleware []Middleware
+	// params are route parameters given other than in the query, e.g. by
+	// a config file. The query parameters take precedence.
+	params map[string]string
+
+	// forward is set when an option touched a key the routeConfig does not
+	// handle, meaning the option is meant for the service.
+	forward bool
+}
+
+var _ service.Service = (*routeConfig)(nil)
+
+func (c *routeConfig) Init(route string, vars service.Vars, opts ...service.Option) error {
+	return nil
+}
+
+func (c *routeConfig) Send(ctx context.Context, msg Message) error {
+	return nil
+}
+
+// SetOption records the route level options.
+func (c *routeConfig) SetOption(key string, value interface{}) {
+	switch key {
+	case optRetry:
+		p := value.(RetryPolicy)
+		c.retry = &p
+	case optLimit:
+		l := value.(RateLimit)
+		c.limit = &l
+	case optBreaker:
+		p := value.(BreakerPolicy)
+		c.breaker = &p
+	case optTags:
+		c.tags = append(c.tags, value.([]string)...)
+	case optDigest:
+		p := value.(DigestPolicy)
+		c.digest = &p
+	case optName:
+		c.name = value.(string)
+	case optParams:
+		c.params = value.(map[string]string)
+	case optMiddleware:
+		c.middleware = append(c.middleware, value.([]Middleware)...)
+	default:
+		c.forward = true
+	}
+}
+
+// newRouteConfig applies opts to a new routeConfig and returns it along
+// with the options that must be passed on to the service.
+func newRouteConfig(opts []service.Option) (*routeConfig, []service.Option) {
+	c := &routeConfig{}
+	var svcOpts []service.Option
+	for _, opt := range opts {
+		c.forward = false
+		opt(c)
+		if c.forward {
+			svcOpts = append(svcOpts, opt)
+		}
+	}
+	return c, svcOpts
+}
+
+// parseParams reads the route level query parameters from vars and removes
+// them. Parameters override options given to Add, except for tags which
+// are added to the ones given with WithTags.
+func (c *routeConfig) parseParams(vars service.Vars) error {
+	params := make(map[string]string)
+	for k, v := range c.params {
+		params[k] = v
+	}
+	for _, k := range routeParams {
+		if v, ok := vars[k]; ok {
+			params[k] = v
+			delete(vars, k)
+		}
+	}
+
+	if tags, ok := params["tag"]; ok {
+		c.tags = append(c.tags, splitTags(tags)...)
+	}
+
+	if err := c.parseRetryParams(params); err != nil {
+		return err
+	}
+	if err := c.parseLimitParams(params); err != nil {
+		return err
+	}
+	if err := c.parseDigestParams(params); err != nil {
+		return err
+	}
+	if c.digest != nil && c.digest.Interval <= 0 {
+		return fmt.Errorf("digest of route has no interval")
+	}
+	return nil
+}
+
+// parseRetryParams parses the retries and backoff parameters. They start
+// from DefaultRetryPolicy when the route has no retry option.
+func (c *routeConfig) parseRetryParams(params map[string]string) error {
+	retries, hasRetries := params["retries"]
+	backoff, hasBackoff := params["backoff"]
+	if !hasRetries && !hasBackoff {
+		return nil
+	}
+
+	p := DefaultRetryPolicy()
+	if c.retry != nil {
+		p = *c.retry
+	}
+
+	if hasRetries {
+		n, err := strconv.Atoi(retries)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid retries %q", retries)
+		}
+		p.MaxAttempts = n + 1
+	}
+
+	if hasBackoff {
+		d, err := time.ParseDuration(backoff)
+		if err != nil {
+			return fmt.Errorf("invalid backoff %q: %w", backoff, err)
+		}
+		p.InitialBackoff = d
+	}
+
+	c.retry = &p
+	return nil
+}
+
+// parseLimitParams parses the rate, burst and limit parameters, e.g.
+// "?rate=1/s&burst=5&limit=drop".
+func (c *routeConfig) parseLimitParams(params map[string]string) error {
+	rate, hasRate := params["rate"]
+	burst, hasBurst := params["burst"]
+	mode, hasMode := params["limit"]
+	if !hasRate && !hasBurst && !hasMode {
+		return nil
+	}
+
+	var l RateLimit
+	if c.limit != nil {
+		l = *c.limit
+	}
+
+	if hasRate {
+		r, err := parseRate(rate)
+		if err != nil {
+			return err
+		}
+		l.Rate = r
+	}
+
+	if hasBurst {
+		b, err := strconv.Atoi(burst)
+		if err != nil || b < 1 {
+			return fmt.Errorf("invalid burst %q", burst)
+		}
+		l.Burst = b
+	}
+
+	if hasMode {
+		m, err := parseLimitMode(mode)
+		if err != nil {
+			return err
+		}
+		l.Mode = m
+	}
+
+	if l.Rate <= 0 {
+		return fmt.Errorf("rate limit of route has no rate")
+	}
+	c.limit = &l
+	return nil
+}
+
+// parseDigestParams parses the digest and digest_max parameters.
+func (c *routeConfig) parseDigestParams(params map[string]string) error {
+	interval, hasInterval := params["digest"]
+	max, hasMax := params["digest_max"]
+	if !hasInterval && !hasMax {
+		return nil
+	}
+
+	var p DigestPolicy
+	if c.digest != nil {
+		p = *c.digest
+	}
+
+	if hasInterval {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			return fmt.Errorf("invalid digest %q: %w", interval, err)
+		}
+		p.Interval = d
+	}
+
+	if hasMax {
+		n, err := strconv.Atoi(max)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid digest_max %q", max)
+		}
+		p.Max = n
+	}
+
+	c.digest = &p
+	return nil
+}
@@ -0,0 +1,524 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package mio
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/phea/mio/pkg/service"
+)
+
+const (
+	// outboxFile is the name of the log file inside the outbox directory.
+	outboxFile = "outbox.log"
+	// deadLetterFile is the name of the file holding the dead letters
+	// inside the outbox directory.
+	deadLetterFile = "deadletter.log"
+)
+
+// DefaultOutboxMaxAttempts is the default number of failed deliveries
+// after which a message is moved to the dead letters, see
+// Outbox.SetMaxAttempts.
+const DefaultOutboxMaxAttempts = 10
+
+var (
+	ErrOutboxClosed = fmt.Errorf("outbox is closed")
+)
+
+// outboxRecord is a line of the outbox log. A record with a message adds an
+// entry, a record without one updates the number of failed attempts of the
+// entry with the same ID, or acknowledges it if Attempts is 0.
+type outboxRecord struct {
+	ID       uint64    `json:"id"`
+	Route    string    `json:"route,omitempty"`
+	Msg      *Message  `json:"msg,omitempty"`
+	Time     time.Time `json:"time"`
+	Attempts int       `json:"attempts,omitempty"`
+}
+
+// DeadLetter is a message the outbox gave up delivering, because the
+// route rejected it for good or because it failed too many times.
+type DeadLetter struct {
+	ID    uint64  `json:"id"`
+	Route string  `json:"route"`
+	Msg   Message `json:"msg"`
+	// Attempts is the number of failed deliveries, and Err the error of
+	// the last one.
+	Attempts int       `json:"attempts"`
+	Err      string    `json:"error"`
+	Time     time.Time `json:"time"`
+}
+
+// outboxEntry is a message waiting to be delivered to a route.
+type outboxEntry struct {
+	outboxRecord
+	busy bool // a delivery is in flight
+}
+
+// Outbox is a file backed write-ahead log of the messages waiting to be
+// delivered. Every message is written to the outbox before it is sent and
+// acknowledged once the route accepted it, so messages whose delivery
+// failed, or was interrupted by a crash, are retried by the Notifier's
+// outbox worker, including after a restart.
+//
+// Messages the route rejects with a permanent error, see
+// service.IsPermanent, or that failed the maximum number of attempts are
+// moved to the dead letters instead of being retried. Other errors, such
+// as an unreachable endpoint, are retried.
+//
+// Entries are keyed by the raw route, credentials included, so the outbox
+// directory must be kept private.
+type Outbox struct {
+	mu          sync.Mutex
+	path        string
+	f           *os.File
+	entries     map[uint64]*outboxEntry
+	nextID      uint64
+	maxAttempts int
+	// stale is the number of records written since the last compaction.
+	stale int
+}
+
+// OpenOutbox opens the outbox stored in dir, creating the directory if
+// needed, and loads the entries that were not acknowledged.
+func OpenOutbox(dir string) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	o := &Outbox{
+		path:        filepath.Join(dir, outboxFile),
+		entries:     make(map[uint64]*outboxEntry),
+		nextID:      1,
+		maxAttempts: DefaultOutboxMaxAttempts,
+	}
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+
+	// rewrite the log with the pending entries only so it does not grow
+	// across restarts.
+	if err := o.compact(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// load replays the log file. A truncated last line, left by a crash in the
+// middle of a write, is ignored.
+func (o *Outbox) load() error {
+	f, err := os.Open(o.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec outboxRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+
+		switch {
+		case rec.Msg != nil:
+			o.entries[rec.ID] = &outboxEntry{outboxRecord: rec}
+		case rec.Attempts > 0:
+			if e, ok := o.entries[rec.ID]; ok {
+				e.Attempts = rec.Attempts
+			}
+		default:
+			delete(o.entries, rec.ID)
+		}
+		if rec.ID >= o.nextID {
+			o.nextID = rec.ID + 1
+		}
+	}
+	return scanner.Err()
+}
+
+// compact rewrites the log with the pending entries and reopens it for
+// appending. The caller must hold the lock or own the outbox exclusively.
+func (o *Outbox) compact() error {
+	tmp := o.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, e := range o.sorted() {
+		if err := enc.Encode(e.outboxRecord); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if o.f != nil {
+		o.f.Close()
+	}
+	if err := os.Rename(tmp, o.path); err != nil {
+		return err
+	}
+
+	o.f, err = os.OpenFile(o.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	o.stale = 0
+	return err
+}
+
+// checkpoint compacts the log if records were written since the last
+// compaction, so that it only holds the live entries.
+func (o *Outbox) checkpoint() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.f == nil || o.stale == 0 {
+		return nil
+	}
+	return o.compact()
+}
+
+// sorted returns the entries ordered by ID, i.e. by the time they were
+// added.
+func (o *Outbox) sorted() []*outboxEntry {
+	entries := make([]*outboxEntry, 0, len(o.entries))
+	for _, e := range o.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+	return entries
+}
+
+// write appends a record to the log and syncs it to disk. The caller must
+// hold the lock.
+func (o *Outbox) write(rec outboxRecord) error {
+	if o.f == nil {
+		return ErrOutboxClosed
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := o.f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	o.stale++
+	return o.f.Sync()
+}
+
+// put adds a message for the route. The entry is returned busy, the caller
+// must either ack or release it.
+func (o *Outbox) put(route string, msg Message) (uint64, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	rec := outboxRecord{ID: o.nextID, Route: route, Msg: &msg, Time: time.Now()}
+	if err := o.write(rec); err != nil {
+		return 0, err
+	}
+
+	o.nextID++
+	o.entries[rec.ID] = &outboxEntry{outboxRecord: rec, busy: true}
+	return rec.ID, nil
+}
+
+// ack removes a delivered entry. The log is compacted once no entries are
+// left pending.
+func (o *Outbox) ack(id uint64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err := o.write(outboxRecord{ID: id}); err != nil {
+		return err
+	}
+
+	delete(o.entries, id)
+	if len(o.entries) == 0 {
+		return o.compact()
+	}
+	return nil
+}
+
+// SetMaxAttempts sets the number of failed deliveries after which a
+// message is moved to the dead letters, 0 for no limit. It defaults to
+// DefaultOutboxMaxAttempts.
+func (o *Outbox) SetMaxAttempts(n int) {
+	o.mu.Lock()
+	o.maxAttempts = n
+	o.mu.Unlock()
+}
+
+// fail records a failed delivery of an entry. The entry is moved to the
+// dead letters if the error is permanent, see service.IsPermanent, or if
+// it failed the maximum number of attempts, and is released to be retried
+// otherwise. It reports whether the entry was moved.
+func (o *Outbox) fail(id uint64, err error) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	e, ok := o.entries[id]
+	if !ok {
+		return false
+	}
+	e.busy = false
+
+	attempts := e.Attempts + 1
+	if !service.IsPermanent(err) && (o.maxAttempts <= 0 || attempts < o.maxAttempts) {
+		if o.write(outboxRecord{ID: id, Attempts: attempts}) == nil {
+			e.Attempts = attempts
+		}
+		return false
+	}
+	return o.deadLetter(e, attempts, err)
+}
+
+// discard moves an entry to the dead letters without counting an
+// attempt. It reports whether the entry was moved.
+func (o *Outbox) discard(id uint64, err error) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	e, ok := o.entries[id]
+	if !ok {
+		return false
+	}
+	e.busy = false
+	return o.deadLetter(e, e.Attempts, err)
+}
+
+// deadLetter moves the entry to the dead letters. The caller must hold the
+// lock.
+func (o *Outbox) deadLetter(e *outboxEntry, attempts int, err error) bool {
+	dl := DeadLetter{
+		ID:       e.ID,
+		Route:    e.Route,
+		Msg:      *e.Msg,
+		Attempts: attempts,
+		Err:      err.Error(),
+		Time:     time.Now(),
+	}
+	if o.bury(dl) != nil || o.write(outboxRecord{ID: e.ID}) != nil {
+		return false
+	}
+	delete(o.entries, e.ID)
+	if len(o.entries) == 0 {
+		o.compact()
+	}
+	return true
+}
+
+// bury appends the dead letter to the dead letter file and syncs it to
+// disk. The caller must hold the lock.
+func (o *Outbox) bury(dl DeadLetter) error {
+	if o.f == nil {
+		return ErrOutboxClosed
+	}
+
+	data, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(filepath.Dir(o.path), deadLetterFile)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// DeadLetters returns the messages the outbox gave up delivering, oldest
+// first. They are kept until the dead letter file is removed.
+func (o *Outbox) DeadLetters() ([]DeadLetter, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	f, err := os.Open(filepath.Join(filepath.Dir(o.path), deadLetterFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var dls []DeadLetter
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var dl DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &dl); err != nil {
+			continue
+		}
+		dls = append(dls, dl)
+	}
+	return dls, scanner.Err()
+}
+
+// release marks an entry whose delivery failed as ready to be retried.
+func (o *Outbox) release(id uint64) {
+	o.mu.Lock()
+	if e, ok := o.entries[id]; ok {
+		e.busy = false
+	}
+	o.mu.Unlock()
+}
+
+// claim returns the pending entries that are not being delivered and marks
+// them busy.
+func (o *Outbox) claim() []outboxRecord {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var recs []outboxRecord
+	for _, e := range o.sorted() {
+		if !e.busy {
+			e.busy = true
+			recs = append(recs, e.outboxRecord)
+		}
+	}
+	return recs
+}
+
+// Pending returns the number of messages waiting to be delivered.
+func (o *Outbox) Pending() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries)
+}
+
+// Close closes the log file. Pending entries are kept on disk.
+func (o *Outbox) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.f == nil {
+		return nil
+	}
+	err := o.f.Close()
+	o.f = nil
+	return err
+}
+
+// SetOutbox makes the Notifier write every message to the outbox before
+// delivering it, and starts a worker that retries the pending messages
+// every interval until the Notifier is closed. Pending messages left by a
+// previous process are retried right away, so the routes must be added
+// before, see Drain. The Notifier takes ownership of the outbox and closes
+// it on Close.
+func (n *Notifier) SetOutbox(o *Outbox, interval time.Duration) {
+	n.mu.Lock()
+	n.outbox = o
+	n.mu.Unlock()
+
+	n.goWorker(func(stop <-chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			ctx, cancel := stopContext(stop)
+			n.Drain(ctx)
+			cancel()
+
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// undelivered reports whether the error kept the message from reaching
+// the route's service, in which case the delivery is not counted as a
+// failed attempt.
+func undelivered(err error) bool {
+	return errors.Is(err, ErrCircuitOpen) ||
+		errors.Is(err, ErrRateLimited) ||
+		errors.Is(err, ErrNotifierClosed) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
+
+// Drain tries to deliver every pending message of the outbox once and
+// returns the number of messages still pending. Messages for routes that
+// are not part of the Notifier are moved to the dead letters. The log is
+// then compacted to the pending messages.
+func (n *Notifier) Drain(ctx context.Context) int {
+	routes, s := n.snapshot()
+	if s.outbox == nil {
+		return 0
+	}
+
+	byRaw := make(map[string]*route, len(routes))
+	for _, r := range routes {
+		for _, m := range r.members() {
+			byRaw[m.raw] = m
+		}
+	}
+
+	for _, rec := range s.outbox.claim() {
+		if ctx.Err() != nil {
+			s.outbox.release(rec.ID)
+			continue
+		}
+		r, ok := byRaw[rec.Route]
+		if !ok {
+			if s.outbox.discard(rec.ID, ErrRouteNotFound) {
+				n.log().Warn("outbox message moved to the dead letters, route not found",
+					"route", Redact(rec.Route), "title", rec.Msg.Title)
+			}
+			continue
+		}
+
+		n.deliver(ctx, &delivery{route: r, msg: *rec.Msg, s: s, outboxID: rec.ID})
+	}
+	if err := s.outbox.checkpoint(); err != nil {
+		n.log().Warn("outbox not compacted", "error", err)
+	}
+	return s.outbox.Pending()
+}
@@ -0,0 +1,310 @@
+package mio
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/phea/mio/pkg/service"
+)
+
+// TestOutboxResume tests that a message that failed is kept on disk and
+// delivered by another Notifier once the route is back.
+func TestOutboxResume(t *testing.T) {
+	var up int32
+	var delivered int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&up) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		atomic.AddInt32(&delivered, 1)
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	route := "json://" + testHost(ts)
+
+	o, err := OpenOutbox(dir)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var n1 Notifier
+	n1.Must(route, service.SetTLS(false))
+	n1.SetOutbox(o, time.Hour)
+
+	res := n1.BroadcastContext(context.Background(), Message{Title: "job failed"}).Results[0]
+	if res.OK() || !res.Pending {
+		t.Fatalf("expected a pending failure, got %+v", res)
+	}
+	if err := n1.Close(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// simulate a restart once the route is reachable again.
+	atomic.StoreInt32(&up, 1)
+	o, err = OpenOutbox(dir)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if o.Pending() != 1 {
+		t.Fatalf("expected 1 pending message, got %d", o.Pending())
+	}
+
+	var n2 Notifier
+	n2.Must(route, service.SetTLS(false))
+	n2.mu.Lock()
+	n2.outbox = o
+	n2.mu.Unlock()
+
+	if pending := n2.Drain(context.Background()); pending != 0 {
+		t.Errorf("expected no pending messages, got %d", pending)
+	}
+	if atomic.LoadInt32(&delivered) != 1 {
+		t.Errorf("expected the message to be delivered once, got %d", delivered)
+	}
+	n2.Close(context.Background())
+
+	o, err = OpenOutbox(dir)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer o.Close()
+	if o.Pending() != 0 {
+		t.Errorf("expected acknowledged messages to be dropped, got %d", o.Pending())
+	}
+}
+
+// TestOutboxWorker tests that the worker started by SetOutbox drains the
+// messages left by a previous process.
+func TestOutboxWorker(t *testing.T) {
+	delivered := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- r.URL.Path
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	route := "json://" + testHost(ts) + "/hook"
+
+	o, err := OpenOutbox(dir)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := o.put(route, Message{Title: "left over"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	o.Close()
+
+	o, err = OpenOutbox(dir)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var n Notifier
+	n.Must(route, service.SetTLS(false))
+	n.SetOutbox(o, time.Hour)
+	defer n.Close(context.Background())
+
+	select {
+	case path := <-delivered:
+		if path != "/hook" {
+			t.Errorf("expected path to be /hook, got %s", path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the pending message to be delivered")
+	}
+}
+
+// TestOutboxDeadLetter tests that a message rejected for good is moved to
+// the dead letters rather than retried.
+func TestOutboxDeadLetter(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	o, err := OpenOutbox(t.TempDir())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var n Notifier
+	n.Must("json://"+testHost(ts), service.SetTLS(false))
+	n.mu.Lock()
+	n.outbox = o
+	n.mu.Unlock()
+	defer n.Close(context.Background())
+
+	res := n.BroadcastContext(context.Background(), Message{Title: "job failed"}).Results[0]
+	if res.OK() || res.Pending || !res.DeadLetter {
+		t.Fatalf("expected the message to be dead-lettered, got %+v", res)
+	}
+	if pending := n.Drain(context.Background()); pending != 0 {
+		t.Errorf("expected no pending messages, got %d", pending)
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("expected the message not to be retried, got %d calls", calls)
+	}
+
+	dls, err := o.DeadLetters()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(dls) != 1 || dls[0].Msg.Title != "job failed" || dls[0].Attempts != 1 || dls[0].Err == "" {
+		t.Errorf("expected the message in the dead letters, got %+v", dls)
+	}
+}
+
+// TestOutboxMaxAttempts tests that the failed attempts are persisted, and
+// that a message is dead-lettered once it failed the maximum number of
+// attempts.
+func TestOutboxMaxAttempts(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	route := "json://" + testHost(ts)
+
+	o, err := OpenOutbox(dir)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var n1 Notifier
+	n1.Must(route, service.SetTLS(false))
+	n1.mu.Lock()
+	n1.outbox = o
+	n1.mu.Unlock()
+
+	res := n1.BroadcastContext(context.Background(), Message{Title: "job failed"}).Results[0]
+	if !res.Pending {
+		t.Fatalf("expected a pending failure, got %+v", res)
+	}
+	if pending := n1.Drain(context.Background()); pending != 1 {
+		t.Fatalf("expected the message to be pending, got %d", pending)
+	}
+	n1.Close(context.Background())
+
+	// the count of attempts survives a restart.
+	o, err = OpenOutbox(dir)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer o.Close()
+	o.SetMaxAttempts(3)
+	for _, rec := range o.claim() {
+		if rec.Attempts != 2 {
+			t.Errorf("expected 2 failed attempts, got %d", rec.Attempts)
+		}
+		o.release(rec.ID)
+	}
+
+	var n2 Notifier
+	n2.Must(route, service.SetTLS(false))
+	n2.mu.Lock()
+	n2.outbox = o
+	n2.mu.Unlock()
+
+	if pending := n2.Drain(context.Background()); pending != 0 {
+		t.Errorf("expected the message to be dead-lettered, got %d pending", pending)
+	}
+	if dls, _ := o.DeadLetters(); len(dls) != 1 || dls[0].Attempts != 3 {
+		t.Errorf("expected the message in the dead letters after 3 attempts, got %+v", dls)
+	}
+	if atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+}
+
+// TestOutboxUnreachable tests that a message for an endpoint refusing
+// connections is kept to be replayed rather than dead-lettered.
+func TestOutboxUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := strings.Replace(ln.Addr().String(), "127.0.0.1", "localhost", 1)
+	ln.Close()
+
+	o, err := OpenOutbox(t.TempDir())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var n Notifier
+	n.Must("json://"+addr, service.SetTLS(false))
+	n.mu.Lock()
+	n.outbox = o
+	n.mu.Unlock()
+	defer n.Close(context.Background())
+
+	res := n.BroadcastContext(context.Background(), Message{Title: "job failed"}).Results[0]
+	if res.OK() || !res.Pending || res.DeadLetter {
+		t.Fatalf("expected a pending failure, got %+v", res)
+	}
+	if pending := n.Drain(context.Background()); pending != 1 {
+		t.Errorf("expected the message to stay pending, got %d", pending)
+	}
+	if dls, _ := o.DeadLetters(); len(dls) != 0 {
+		t.Errorf("expected no dead letters, got %+v", dls)
+	}
+}
+
+// TestOutboxCompaction tests that messages for unknown routes are moved to
+// the dead letters, and that the log is compacted to the pending messages
+// while some remain.
+func TestOutboxCompaction(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	o, err := OpenOutbox(dir)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := o.put("json://localhost:1/removed", Message{Title: "stale"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	o.release(1)
+
+	var n Notifier
+	n.Must("json://"+testHost(ts), service.SetTLS(false))
+	n.mu.Lock()
+	n.outbox = o
+	n.mu.Unlock()
+	defer n.Close(context.Background())
+
+	n.BroadcastContext(context.Background(), Message{Title: "down"})
+	for i := 0; i < 3; i++ {
+		if pending := n.Drain(context.Background()); pending != 1 {
+			t.Fatalf("expected 1 pending message, got %d", pending)
+		}
+	}
+
+	if dls, _ := o.DeadLetters(); len(dls) != 1 || dls[0].Msg.Title != "stale" {
+		t.Errorf("expected the message of the removed route in the dead letters, got %+v", dls)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, outboxFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 1 {
+		t.Errorf("expected the log to hold the pending message only, got %d lines:\n%s", lines, data)
+	}
+}
@@ -0,0 +1,140 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package mio
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/phea/mio/pkg/service"
+)
+
+// ErrPreviewUnsupported is returned when previewing a route whose service
+// cannot build what it would send without sending it.
+var ErrPreviewUnsupported = fmt.Errorf("service does not support previews")
+
+// Preview is what a route would send for a message: the HTTP request of
+// the JSON and XML services, the email of the SMTP service and the D-Bus
+// call of the Gnome service. Secrets are masked.
+type Preview = service.Preview
+
+// SetDryRun turns the dry-run mode of the Notifier on or off. In dry-run
+// mode broadcasts build what every selected route would send, reported by
+// the Preview of its result, without sending anything. The rules and
+// middlewares apply; retries, rate limits, circuit breakers, digests,
+// duplicate suppression and the outbox do not.
+func (n *Notifier) SetDryRun(on bool) {
+	n.mu.Lock()
+	n.dryRun = on
+	n.mu.Unlock()
+}
+
+// Preview returns what the route would send for msg, without adding the
+// route or sending anything. The route's placeholders are resolved and
+// the middlewares of the Notifier and the route applied, as they would be
+// by a broadcast.
+func (n *Notifier) Preview(rawRoute string, msg Message, opts ...service.Option) (Preview, error) {
+	r, err := n.newRoute(rawRoute, opts...)
+	if err != nil {
+		return Preview{}, err
+	}
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+
+	_, s := n.snapshot()
+	res := n.previewRoute(context.Background(), r, msg, s)
+	if res.Err != nil {
+		return Preview{}, res.Err
+	}
+	return *res.Preview, nil
+}
+
+// previewRoute builds what the route would send, through the middlewares
+// of the Notifier and the route. A failover chain is previewed like it is
+// delivered: its members are tried in order until one of them can be
+// previewed.
+func (n *Notifier) previewRoute(ctx context.Context, r *route, msg Message, s settings) Result {
+	if r.chain != nil {
+		var tried []Result
+		for _, m := range r.chain {
+			res := n.previewRoute(ctx, m, msg, s)
+			tried = append(tried, res)
+			if res.OK() || ctx.Err() != nil {
+				break
+			}
+		}
+
+		res := tried[len(tried)-1]
+		res.Failover = tried
+		return res
+	}
+
+	res := r.result()
+	res.DryRun = true
+
+	send := func(ctx context.Context, msg Message) error {
+		p, err := r.preview(msg)
+		if err != nil {
+			return err
+		}
+		resultFrom(ctx).Preview = &p
+		return nil
+	}
+	send = wrap(send, r.middleware)
+	send = wrap(send, s.middleware)
+
+	start := time.Now()
+	res.Err = send(withRoute(withResult(ctx, &res), r), msg)
+	res.Duration = time.Since(start)
+
+	args := []interface{}{"scheme", res.Scheme, "route", res.Route, "name", res.Name}
+	if res.Err != nil {
+		args = append(args, "error", res.Err)
+	}
+	n.log().Debug("message previewed", args...)
+	return res
+}
+
+// preview asks the route's service what it would send for msg, and masks
+// the secrets of the route in it. Headers whose name denotes a secret,
+// such as Authorization, are masked altogether.
+func (r *route) preview(msg Message) (Preview, error) {
+	pv, ok := r.svc.(service.Previewer)
+	if !ok {
+		return Preview{}, fmt.Errorf("%w: %s", ErrPreviewUnsupported, r.scheme)
+	}
+
+	p, err := pv.Preview(msg)
+	if err != nil {
+		return Preview{}, redactError(err, r.secrets)
+	}
+
+	p.Endpoint = maskSecrets(p.Endpoint, r.secrets)
+	if strings.Contains(p.Endpoint, "://") {
+		p.Endpoint = Redact(p.Endpoint)
+	}
+	if p.Headers != nil {
+		headers := make(map[string]string, len(p.Headers))
+		for k, v := range p.Headers {
+			if isSecretVar(k) {
+				v = "xxxxx"
+			}
+			headers[k] = maskSecrets(v, r.secrets)
+		}
+		p.Headers = headers
+	}
+	p.Body = maskSecrets(p.Body, r.secrets)
+	return p, nil
+}
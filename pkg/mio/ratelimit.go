@@ -0,0 +1,355 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package mio
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrRateLimited = fmt.Errorf("route is rate limited")
+)
+
+// LimitMode selects what happens to a message when a rate limit has no
+// tokens left.
+type LimitMode int
+
+const (
+	// LimitBlock waits for a token before sending.
+	LimitBlock LimitMode = iota
+	// LimitDrop fails the delivery with ErrRateLimited.
+	LimitDrop
+	// LimitQueue returns right away and sends the message in the
+	// background once a token is available. Queued messages are sent one
+	// at a time, in order, and dropped once the queue is full.
+	LimitQueue
+)
+
+func (m LimitMode) String() string {
+	switch m {
+	case LimitBlock:
+		return "block"
+	case LimitDrop:
+		return "drop"
+	case LimitQueue:
+		return "queue"
+	}
+	return "LimitMode(" + strconv.Itoa(int(m)) + ")"
+}
+
+// parseLimitMode parses the name of a LimitMode.
+func parseLimitMode(s string) (LimitMode, error) {
+	for _, m := range []LimitMode{LimitBlock, LimitDrop, LimitQueue} {
+		if m.String() == s {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid limit mode %q", s)
+}
+
+// RateLimit configures a token bucket: it holds up to Burst tokens and is
+// refilled with Rate tokens per second. Every send takes a token.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+	Mode  LimitMode
+	// QueueSize is the number of messages LimitQueue holds, defaults to
+	// DefaultLimitQueueSize.
+	QueueSize int
+}
+
+// DefaultLimitQueueSize is the default size of the queue of LimitQueue.
+const DefaultLimitQueueSize = 100
+
+// parseRate parses a rate such as "1/s", "30/m", "100/h" or "5/10s" into
+// tokens per second.
+func parseRate(s string) (float64, error) {
+	count, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+
+	// allow the unit alone, e.g. "s" for "1s".
+	if per != "" && (per[0] < '0' || per[0] > '9') {
+		per = "1" + per
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return n / d.Seconds(), nil
+}
+
+// LimitState describes how rate limiting affected a delivery.
+type LimitState struct {
+	Mode LimitMode
+	// Waited is the time spent waiting for tokens.
+	Waited time.Duration
+	// Tokens is the lowest number of tokens left in the route's buckets
+	// after the last attempt took its token.
+	Tokens float64
+	// Dropped is set when the message was dropped for lack of tokens, or
+	// of room in the queue.
+	Dropped bool
+	// Queued is set when the message was queued to be sent in the
+	// background, in which case the result does not report its outcome.
+	Queued bool
+}
+
+// limiter is a token bucket.
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	mode   LimitMode
+
+	// queue holds the deliveries waiting for tokens in LimitQueue mode,
+	// nil until the first one, and pending is the number of them not sent
+	// yet.
+	queue     chan *delivery
+	queueSize int
+	pending   int
+}
+
+func newLimiter(l RateLimit) *limiter {
+	burst := float64(l.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	size := l.QueueSize
+	if size <= 0 {
+		size = DefaultLimitQueueSize
+	}
+	return &limiter{
+		rate:      l.Rate,
+		burst:     burst,
+		tokens:    burst,
+		last:      time.Now(),
+		mode:      l.Mode,
+		queueSize: size,
+	}
+}
+
+// advance refills the bucket up to now. The caller must hold the lock.
+func (l *limiter) advance(now time.Time) {
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens += elapsed.Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.last = now
+	}
+}
+
+// available returns the number of tokens in the bucket.
+func (l *limiter) available() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.advance(time.Now())
+	return l.tokens
+}
+
+// reserve takes a token, going into debt if the bucket is empty, and
+// returns how long to wait before the token may be used.
+func (l *limiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.advance(time.Now())
+	l.tokens--
+	if l.tokens >= 0 || l.rate <= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// restore gives back a token taken by reserve.
+func (l *limiter) restore() {
+	l.mu.Lock()
+	l.tokens++
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.mu.Unlock()
+}
+
+// rateGate takes tokens from the scheme and route limiters of a delivery.
+type rateGate struct {
+	limiters []*limiter
+	mode     LimitMode
+
+	acquired int
+	// skip is set when the tokens of the next attempt were already taken.
+	skip bool
+}
+
+// gate returns the rate gate of the delivery, nil if its route is not rate
+// limited. The route's mode takes precedence over the scheme's.
+func (d *delivery) gate() *rateGate {
+	g := &rateGate{}
+	if l := d.s.limits[d.route.scheme]; l != nil {
+		g.limiters = append(g.limiters, l)
+		g.mode = l.mode
+	}
+	if l := d.route.limit; l != nil {
+		g.limiters = append(g.limiters, l)
+		g.mode = l.mode
+	}
+
+	if len(g.limiters) == 0 {
+		return nil
+	}
+	return g
+}
+
+// owner returns the limiter whose mode the gate follows, the route's if
+// it has one.
+func (g *rateGate) owner() *limiter {
+	return g.limiters[len(g.limiters)-1]
+}
+
+// tokens returns the lowest number of tokens left in the limiters.
+func (g *rateGate) tokens() float64 {
+	var min float64
+	for i, l := range g.limiters {
+		if t := l.available(); i == 0 || t < min {
+			min = t
+		}
+	}
+	return min
+}
+
+// lock locks the limiters and returns a function unlocking them. They are
+// always locked in the same order, the scheme's before the route's.
+func (g *rateGate) lock() func() {
+	for _, l := range g.limiters {
+		l.mu.Lock()
+	}
+	return func() {
+		for _, l := range g.limiters {
+			l.mu.Unlock()
+		}
+	}
+}
+
+// allow takes a token from every limiter if they all have one available.
+// The limiters are checked and taken from under their locks, so that
+// concurrent deliveries cannot take the same tokens.
+func (g *rateGate) allow() bool {
+	defer g.lock()()
+	return g.take()
+}
+
+// take is allow for a caller holding the locks of the limiters.
+func (g *rateGate) take() bool {
+	now := time.Now()
+	for _, l := range g.limiters {
+		l.advance(now)
+	}
+
+	for _, l := range g.limiters {
+		if l.tokens < 1 {
+			return false
+		}
+	}
+	for _, l := range g.limiters {
+		l.tokens--
+	}
+	return true
+}
+
+// acquire takes the tokens for an attempt and records the limiter state.
+// The first attempt follows the gate's mode, retries always wait.
+func (g *rateGate) acquire(ctx context.Context, state *LimitState) error {
+	if g.skip {
+		g.skip = false
+		state.Tokens = g.tokens()
+		return nil
+	}
+
+	first := g.acquired == 0
+	g.acquired++
+	if first && g.mode == LimitDrop {
+		if !g.allow() {
+			state.Dropped = true
+			state.Tokens = g.tokens()
+			return ErrRateLimited
+		}
+		state.Tokens = g.tokens()
+		return nil
+	}
+
+	var wait time.Duration
+	for _, l := range g.limiters {
+		if d := l.reserve(); d > wait {
+			wait = d
+		}
+	}
+	state.Tokens = g.tokens()
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		for _, l := range g.limiters {
+			l.restore()
+		}
+		return ctx.Err()
+	case <-timer.C:
+		state.Waited += wait
+		return nil
+	}
+}
+
+// middleware takes the tokens of every attempt before calling next, and
+// records the limiter state in the delivery's result.
+func (g *rateGate) middleware(next SendFunc) SendFunc {
+	return func(ctx context.Context, msg Message) error {
+		res := resultFrom(ctx)
+		if res.Limit == nil {
+			res.Limit = &LimitState{Mode: g.mode}
+		}
+		if err := g.acquire(ctx, res.Limit); err != nil {
+			return err
+		}
+		return next(ctx, msg)
+	}
+}
+
+// SetSchemeRateLimit rate limits all the routes of a scheme together, on
+// top of the limits of the routes themselves.
+func (n *Notifier) SetSchemeRateLimit(scheme string, l RateLimit) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	limits := make(map[string]*limiter, len(n.limits)+1)
+	for k, v := range n.limits {
+		limits[k] = v
+	}
+	limits[scheme] = newLimiter(l)
+	n.limits = limits
+}
@@ -0,0 +1,237 @@
+package mio
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/phea/mio/pkg/service"
+)
+
+// TestParseRate tests the parsing of rates into tokens per second.
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		rate string
+		want float64
+		err  bool
+	}{
+		{"1/s", 1, false},
+		{"30/m", 0.5, false},
+		{"5/10s", 0.5, false},
+		{"2/500ms", 4, false},
+		{"1", 0, true},
+		{"0/s", 0, true},
+		{"1/x", 0, true},
+	}
+
+	for _, test := range tests {
+		got, err := parseRate(test.rate)
+		if (err != nil) != test.err {
+			t.Errorf("expected error for %s to be %t, got %v", test.rate, test.err, err)
+		}
+		if got != test.want {
+			t.Errorf("expected %s to be %v per second, got %v", test.rate, test.want, got)
+		}
+	}
+}
+
+// TestRateLimitDrop tests that messages are dropped once the bucket is
+// empty.
+func TestRateLimitDrop(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	var n Notifier
+	n.Must("json://"+ts.host()+"?rate=1/h&burst=1&limit=drop", service.SetTLS(false))
+
+	res := n.BroadcastContext(context.Background(), Message{Title: "first"}).Results[0]
+	if res.Err != nil {
+		t.Fatalf("expected no error, got %v", res.Err)
+	}
+
+	res = n.BroadcastContext(context.Background(), Message{Title: "second"}).Results[0]
+	if !errors.Is(res.Err, ErrRateLimited) {
+		t.Errorf("expected ErrRateLimited, got %v", res.Err)
+	}
+	if res.Limit == nil || !res.Limit.Dropped || res.Limit.Mode != LimitDrop {
+		t.Errorf("expected the limit state to report the drop, got %+v", res.Limit)
+	}
+	if ts.hits() != 1 {
+		t.Errorf("expected 1 request, got %d", ts.hits())
+	}
+}
+
+// TestRateLimitDropConcurrent tests that concurrent deliveries do not take
+// more tokens than the bucket holds.
+func TestRateLimitDropConcurrent(t *testing.T) {
+	l := newLimiter(RateLimit{Rate: 1.0 / 3600, Burst: 5, Mode: LimitDrop})
+
+	var (
+		allowed int32
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g := &rateGate{limiters: []*limiter{l}, mode: LimitDrop}
+			if g.allow() {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if allowed != 5 {
+		t.Errorf("expected 5 deliveries to be allowed, got %d", allowed)
+	}
+	if tokens := l.available(); tokens < 0 || tokens >= 1 {
+		t.Errorf("expected the bucket to be empty, got %v tokens", tokens)
+	}
+}
+
+// TestRateLimitBlock tests that sends wait for a token.
+func TestRateLimitBlock(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	var n Notifier
+	n.SetSchemeRateLimit("json", RateLimit{Rate: 20, Burst: 1})
+	n.Must("json://"+ts.host(), service.SetTLS(false))
+	n.Must("json://"+ts.host()+"/other", service.SetTLS(false))
+
+	report := n.BroadcastContext(context.Background(), Message{Title: "t"})
+	if err := report.Err(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var waited time.Duration
+	for _, res := range report.Results {
+		waited += res.Limit.Waited
+	}
+	if waited <= 0 {
+		t.Errorf("expected one of the routes to wait for a token")
+	}
+}
+
+// TestRateLimitQueue tests that queued messages are sent in the background.
+func TestRateLimitQueue(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	var n Notifier
+	n.Must("json://"+ts.host(), service.SetTLS(false),
+		WithRateLimit(RateLimit{Rate: 20, Burst: 1, Mode: LimitQueue}))
+
+	n.BroadcastContext(context.Background(), Message{Title: "first"})
+	res := n.BroadcastContext(context.Background(), Message{Title: "second"}).Results[0]
+	if res.Limit == nil || !res.Limit.Queued || !res.Queued {
+		t.Fatalf("expected the message to be queued, got %+v", res.Limit)
+	}
+	if res.OK() {
+		t.Errorf("expected a queued message not to be reported as delivered")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for ts.hits() != 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ts.hits() != 2 {
+		t.Errorf("expected the queued message to be sent, got %d requests", ts.hits())
+	}
+	n.Close(context.Background())
+}
+
+// TestRateLimitQueueOrder tests that queued messages are sent in order,
+// and dropped once the queue is full.
+func TestRateLimitQueueOrder(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	var n Notifier
+	n.Must("json://"+ts.host(), service.SetTLS(false),
+		WithRateLimit(RateLimit{Rate: 20, Burst: 1, Mode: LimitQueue, QueueSize: 3}))
+
+	var want []string
+	for i := 0; i < 8; i++ {
+		body := strconv.Itoa(i)
+		report := n.BroadcastContext(context.Background(), Message{Title: "t", Body: body})
+		res := report.Results[0]
+		switch {
+		case res.Limit.Dropped:
+			if !errors.Is(res.Err, ErrRateLimited) {
+				t.Errorf("expected ErrRateLimited, got %v", res.Err)
+			}
+		case res.Queued:
+			if report.Err() != nil {
+				t.Errorf("expected a queued message not to fail, got %v", report.Err())
+			}
+			want = append(want, body)
+		default:
+			want = append(want, body)
+		}
+	}
+	if len(want) == 8 {
+		t.Fatalf("expected messages to be dropped once the queue is full")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(ts.bodies()) < len(want) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := ts.bodies(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the messages to be sent in order %v, got %v", want, got)
+	}
+	n.Close(context.Background())
+}
+
+// TestRateLimitQueueClose tests that the messages still queued when the
+// Notifier is closed fail without being sent and stay in the outbox.
+func TestRateLimitQueueClose(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	dir := t.TempDir()
+	o, err := OpenOutbox(dir)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var n Notifier
+	n.Must("json://"+ts.host(), service.SetTLS(false),
+		WithRateLimit(RateLimit{Rate: 0.01, Burst: 1, Mode: LimitQueue}))
+	n.SetOutbox(o, time.Hour)
+	logger := &testLogger{}
+	n.SetLogger(logger)
+
+	for i := 0; i < 3; i++ {
+		n.BroadcastContext(context.Background(), Message{Title: strconv.Itoa(i)})
+	}
+	if err := n.Close(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if ts.hits() != 1 {
+		t.Errorf("expected only the first message to be sent, got %d requests", ts.hits())
+	}
+	logger.mu.Lock()
+	logs := strings.Join(logger.lines, "\n")
+	logger.mu.Unlock()
+	if got := strings.Count(logs, ErrNotifierClosed.Error()); got != 2 {
+		t.Errorf("expected the queued messages to fail with ErrNotifierClosed, got:\n%s", logs)
+	}
+
+	o, err = OpenOutbox(dir)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer o.Close()
+	if o.Pending() != 2 {
+		t.Errorf("expected the queued messages to stay in the outbox, got %d", o.Pending())
+	}
+}
@@ -0,0 +1,115 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package mio
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Redact returns the route with its secrets replaced by "xxxxx": the
+// password, and the values of the query parameters whose name denotes a
+// secret, such as token or api_key. The rest of the route is kept as is,
+// placeholders included. Routes are split by hand rather than parsed, so
+// that malformed ones, such as routes with an invalid port, are redacted
+// alike.
+func Redact(route string) string {
+	scheme, rest, ok := strings.Cut(route, "://")
+	if !ok {
+		return route
+	}
+	rest, fragment, hasFragment := strings.Cut(rest, "#")
+	rest, query, hasQuery := strings.Cut(rest, "?")
+
+	authority, path := rest, ""
+	if i := strings.Index(rest, "/"); i >= 0 {
+		authority, path = rest[:i], rest[i:]
+	}
+	if at := strings.LastIndex(authority, "@"); at >= 0 {
+		if user, _, ok := strings.Cut(authority[:at], ":"); ok {
+			authority = user + ":xxxxx" + authority[at:]
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(scheme + "://" + authority + path)
+	if hasQuery {
+		params := strings.Split(query, "&")
+		for i, param := range params {
+			key, _, ok := strings.Cut(param, "=")
+			if name, err := url.QueryUnescape(key); ok && err == nil && isSecretVar(name) {
+				params[i] = key + "=xxxxx"
+			}
+		}
+		b.WriteString("?" + strings.Join(params, "&"))
+	}
+	if hasFragment {
+		b.WriteString("#" + fragment)
+	}
+	return b.String()
+}
+
+// maskSecrets replaces the secrets in s by "xxxxx", including their URL
+// escaped forms.
+func maskSecrets(s string, secrets []string) string {
+	for _, secret := range secrets {
+		for _, form := range []string{secret, url.PathEscape(secret), url.QueryEscape(secret)} {
+			s = strings.ReplaceAll(s, form, "xxxxx")
+		}
+	}
+	return s
+}
+
+// secretError masks secrets in the message of the error it wraps.
+type secretError struct {
+	err     error
+	secrets []string
+}
+
+func (e *secretError) Error() string { return maskSecrets(e.err.Error(), e.secrets) }
+func (e *secretError) Unwrap() error { return e.err }
+
+// redactError wraps err so that the secrets are masked in its message.
+func redactError(err error, secrets []string) error {
+	if err == nil || len(secrets) == 0 {
+		return err
+	}
+	return &secretError{err: err, secrets: secrets}
+}
+
+// secretMarkers are the parts of the names of the variables holding
+// secrets.
+var secretMarkers = []string{"pass", "token", "secret", "key", "auth"}
+
+// isSecretVar reports whether the route variable holds a secret.
+func isSecretVar(name string) bool {
+	name = strings.ToLower(name)
+	for _, m := range secretMarkers {
+		if strings.Contains(name, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// maskVars returns a copy of the route variables with the secrets
+// replaced by "xxxxx".
+func maskVars(vars map[string]string) map[string]string {
+	masked := make(map[string]string, len(vars))
+	for k, v := range vars {
+		if isSecretVar(k) && v != "" {
+			v = "xxxxx"
+		}
+		masked[k] = v
+	}
+	return masked
+}
@@ -0,0 +1,180 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package mio
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/phea/mio/internal/matcher"
+	"github.com/phea/mio/pkg/service"
+)
+
+var (
+	ErrDuplicateScheme = fmt.Errorf("scheme already registered")
+	ErrSchemeNotFound  = fmt.Errorf("scheme not registered")
+)
+
+// serviceMatch pairs a route template with the factory of the service
+// that handles it. A new service is created for every route added.
+type serviceMatch struct {
+	newService func() service.Service
+	matcher    *matcher.Matcher
+}
+
+// Registry holds the services routes are matched to, by scheme. It is
+// safe for concurrent use.
+type Registry struct {
+	mu       sync.RWMutex
+	matchers []serviceMatch
+	specs    map[string]service.Spec // by scheme
+}
+
+// defaultRegistry is the registry of the Notifiers without their own.
+var defaultRegistry = NewRegistry()
+
+// NewRegistry returns a registry holding the built-in services.
+func NewRegistry() *Registry {
+	r := &Registry{specs: make(map[string]service.Spec)}
+	for _, spec := range service.Specs() {
+		if err := r.Register(spec); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
+
+// Register adds the service described by the spec. All the templates of
+// the spec must have the same scheme, which must not be registered yet.
+// Templates are matched from last to first, so they should be ordered
+// least to most specific.
+func (r *Registry) Register(spec service.Spec) error {
+	if spec.Init == nil {
+		return fmt.Errorf("invalid service spec: no Init function")
+	}
+	if len(spec.Template) == 0 {
+		return fmt.Errorf("invalid service spec: no template")
+	}
+
+	var (
+		scheme   string
+		matchers []serviceMatch
+	)
+	for _, t := range spec.Template {
+		if !strings.Contains(t, "://") {
+			return fmt.Errorf("invalid service template %q: no scheme", t)
+		}
+		m, err := matcher.Compile(t)
+		if err != nil {
+			return fmt.Errorf("invalid service template %q: %w", t, err)
+		}
+		if scheme != "" && m.Scheme() != scheme {
+			return fmt.Errorf("invalid service spec: templates of schemes %s and %s", scheme, m.Scheme())
+		}
+		scheme = m.Scheme()
+		matchers = append(matchers, serviceMatch{newService: spec.Init, matcher: m})
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.specs[scheme]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateScheme, scheme)
+	}
+	r.matchers = append(r.matchers[:len(r.matchers):len(r.matchers)], matchers...)
+	r.specs[scheme] = spec
+	return nil
+}
+
+// Unregister removes the service of the scheme. Routes already added keep
+// their service.
+func (r *Registry) Unregister(scheme string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.specs[scheme]; !ok {
+		return fmt.Errorf("%w: %s", ErrSchemeNotFound, scheme)
+	}
+	delete(r.specs, scheme)
+
+	matchers := make([]serviceMatch, 0, len(r.matchers))
+	for _, sm := range r.matchers {
+		if sm.matcher.Scheme() != scheme {
+			matchers = append(matchers, sm)
+		}
+	}
+	r.matchers = matchers
+	return nil
+}
+
+// Schemes returns the registered schemes, sorted.
+func (r *Registry) Schemes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	schemes := make([]string, 0, len(r.specs))
+	for s := range r.specs {
+		schemes = append(schemes, s)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// match returns the most specific service match for the route.
+// NOTE: This function assumes templates are ordered least to most specific.
+func (r *Registry) match(route string) (serviceMatch, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var (
+		m     serviceMatch
+		found bool
+	)
+	for _, sm := range r.matchers {
+		if sm.matcher.IsMatch(route) {
+			m, found = sm, true
+		}
+	}
+	return m, found
+}
+
+// Register adds the service described by the spec to the default
+// registry, used by the Notifiers without their own, see
+// Registry.Register.
+func Register(spec service.Spec) error {
+	return defaultRegistry.Register(spec)
+}
+
+// Unregister removes the service of the scheme from the default registry.
+func Unregister(scheme string) error {
+	return defaultRegistry.Unregister(scheme)
+}
+
+// SetRegistry makes the Notifier match the routes added from now on to
+// the services of the registry instead of the default one.
+func (n *Notifier) SetRegistry(r *Registry) {
+	n.mu.Lock()
+	n.registry = r
+	n.mu.Unlock()
+}
+
+// services returns the registry of the Notifier.
+func (n *Notifier) services() *Registry {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.registry != nil {
+		return n.registry
+	}
+	return defaultRegistry
+}
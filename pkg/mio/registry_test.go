@@ -0,0 +1,123 @@
+package mio
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/phea/mio/pkg/service"
+)
+
+// tixService is a third party service recording the tickets it opens.
+type tixService struct {
+	queue   string
+	tickets *[]string
+}
+
+func (s *tixService) Init(route string, vars service.Vars, opts ...service.Option) error {
+	s.queue = vars["queue"]
+	return nil
+}
+
+func (s *tixService) Send(ctx context.Context, msg Message) error {
+	*s.tickets = append(*s.tickets, s.queue+": "+msg.Title)
+	return nil
+}
+
+func (s *tixService) SetOption(key string, value interface{}) {}
+
+func tixSpec(tickets *[]string) service.Spec {
+	return service.Spec{
+		Template: []string{"tix://{queue}"},
+		Init: func() service.Service {
+			return &tixService{tickets: tickets}
+		},
+	}
+}
+
+// TestRegister tests that services registered at runtime are matched,
+// and that a scheme cannot be registered twice.
+func TestRegister(t *testing.T) {
+	var tickets []string
+	if err := Register(tixSpec(&tickets)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer Unregister("tix")
+
+	if err := Register(tixSpec(&tickets)); !errors.Is(err, ErrDuplicateScheme) {
+		t.Errorf("expected ErrDuplicateScheme, got %v", err)
+	}
+	if err := Register(service.Spec{Template: []string{"json://{host}/x"}, Init: tixSpec(nil).Init}); !errors.Is(err, ErrDuplicateScheme) {
+		t.Errorf("expected built-in schemes to be taken, got %v", err)
+	}
+
+	var n Notifier
+	n.Must("tix://ops")
+	n.BroadcastContext(context.Background(), Message{Title: "disk full"})
+	if len(tickets) != 1 || tickets[0] != "ops: disk full" {
+		t.Errorf("expected a ticket, got %v", tickets)
+	}
+
+	if err := Unregister("tix"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := n.Add("tix://dev"); !errors.Is(err, ErrServiceNotFound) {
+		t.Errorf("expected ErrServiceNotFound once unregistered, got %v", err)
+	}
+	if err := Unregister("tix"); !errors.Is(err, ErrSchemeNotFound) {
+		t.Errorf("expected ErrSchemeNotFound, got %v", err)
+	}
+}
+
+// TestRegisterInvalid tests that malformed specs are rejected.
+func TestRegisterInvalid(t *testing.T) {
+	newTix := tixSpec(nil).Init
+	tests := []service.Spec{
+		{Template: []string{"tix://{queue}"}},
+		{Init: newTix},
+		{Template: []string{"tix://{queue"}, Init: newTix},
+		{Template: []string{"tix"}, Init: newTix},
+		{Template: []string{"tix://{queue}", "tox://{queue}"}, Init: newTix},
+	}
+
+	r := NewRegistry()
+	for _, spec := range tests {
+		if err := r.Register(spec); err == nil {
+			t.Errorf("expected an error for %+v", spec.Template)
+		}
+	}
+}
+
+// TestSetRegistry tests that a Notifier with its own registry matches
+// routes to its services only.
+func TestSetRegistry(t *testing.T) {
+	var tickets []string
+	r := NewRegistry()
+	if err := r.Register(tixSpec(&tickets)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := r.Unregister("gnome"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var own, other Notifier
+	own.SetRegistry(r)
+	if err := own.Add("tix://ops"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if err := own.Add("gnome://"); !errors.Is(err, ErrServiceNotFound) {
+		t.Errorf("expected gnome to be unregistered, got %v", err)
+	}
+	if err := other.Add("tix://ops"); !errors.Is(err, ErrServiceNotFound) {
+		t.Errorf("expected tix not to be in the default registry, got %v", err)
+	}
+	if err := other.Add("gnome://"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	for _, s := range r.Schemes() {
+		if s == "gnome" {
+			t.Errorf("expected gnome not to be listed, got %v", r.Schemes())
+		}
+	}
+}
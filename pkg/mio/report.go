@@ -0,0 +1,121 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package mio
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Result is the outcome of delivering a message to a single route.
+type Result struct {
+	// Route is the route the message was sent to, with credentials redacted.
+	Route string
+	// Name is the name of the route, see WithName.
+	Name string
+	// Scheme is the scheme of the service that handled the route.
+	Scheme string
+	// Tags are the tags of the route.
+	Tags []string
+	// Duration is the time spent delivering the message.
+	Duration time.Duration
+	// Attempts is the number of times the service was called.
+	Attempts int
+	// Err is the error returned by the service, nil on success.
+	Err error
+	// Pending is set when the message failed and was kept in the outbox
+	// to be retried later, and DeadLetter when it failed for good and was
+	// moved to the outbox's dead letters.
+	Pending    bool
+	DeadLetter bool
+	// Limit describes how rate limiting affected the delivery, nil if the
+	// route is not rate limited.
+	Limit *LimitState
+	// Queued is set when the message was queued behind the route's rate
+	// limit, to be sent in the background, in which case the result does
+	// not tell whether it was delivered.
+	Queued bool
+	// Digested is set when the message was added to the route's digest,
+	// to be sent with the others at the end of the interval.
+	Digested bool
+	// DryRun is set when the Notifier is in dry-run mode, in which case
+	// the message was not sent and Preview holds what would have been.
+	DryRun  bool
+	Preview *Preview
+	// Failover holds the results of the members of a failover chain, in the
+	// order they were tried, nil for other routes. The last one is the
+	// member that delivered the message, if any did.
+	Failover []Result
+	// Skipped is set when the Notifier's rules did not select the route,
+	// in which case the message was not sent to it.
+	Skipped bool
+	// Rule is the name of the rule that selected the route, empty if no
+	// rule applied to the message.
+	Rule string
+	// Decision explains why the rules did or did not select the route,
+	// empty if the Notifier has no rules.
+	Decision string
+}
+
+// OK reports whether the message was delivered. Queued and digested
+// messages are not, yet.
+func (r Result) OK() bool {
+	return r.Err == nil && !r.Queued && !r.Digested
+}
+
+// Report holds the results of a broadcast, one per route.
+type Report struct {
+	Results []Result
+	// Suppressed is set when the message was a duplicate suppressed by
+	// the Notifier's dedup policy, in which case there are no results.
+	Suppressed bool
+}
+
+// Sent returns the results of the routes the message was sent to.
+func (r Report) Sent() []Result {
+	var sent []Result
+	for _, res := range r.Results {
+		if !res.Skipped {
+			sent = append(sent, res)
+		}
+	}
+	return sent
+}
+
+// Failed returns the results of the routes that failed. Queued and
+// digested messages have not failed, yet.
+func (r Report) Failed() []Result {
+	var failed []Result
+	for _, res := range r.Results {
+		if res.Err != nil {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// Err returns an error describing every failed route, or nil if all
+// routes succeeded.
+func (r Report) Err() error {
+	failed := r.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+
+	msgs := make([]string, len(failed))
+	for i, res := range failed {
+		msgs[i] = fmt.Sprintf("%s: %v", res.Route, res.Err)
+	}
+	return fmt.Errorf("%d of %d routes failed: %s",
+		len(failed), len(r.Sent()), strings.Join(msgs, "; "))
+}
@@ -0,0 +1,127 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package mio
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/phea/mio/pkg/service"
+)
+
+// RetryPolicy controls how a failed send is retried. The zero value sends
+// once and never retries.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of sends, including the first one.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between two attempts, 0 means no cap.
+	MaxBackoff time.Duration
+	// Multiplier grows the backoff after every attempt, defaults to 2.
+	Multiplier float64
+	// Jitter randomizes each backoff by up to the given fraction, e.g. 0.2
+	// waits anywhere between 80% and 120% of the backoff.
+	Jitter float64
+	// MaxElapsed stops retrying once the next attempt would start after the
+	// given time since the first attempt, 0 means no limit.
+	MaxElapsed time.Duration
+	// Retryable classifies errors worth retrying, defaults to
+	// service.IsTemporary.
+	Retryable func(error) bool
+}
+
+// DefaultRetryPolicy returns a policy making up to 3 attempts with an
+// exponential backoff starting at 500ms.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		MaxElapsed:     time.Minute,
+	}
+}
+
+// SetRetryPolicy sets the retry policy used by routes that do not
+// override it with WithRetry or query parameters.
+func (n *Notifier) SetRetryPolicy(p RetryPolicy) {
+	n.mu.Lock()
+	n.retry = p
+	n.mu.Unlock()
+}
+
+// backoff returns the wait after the given attempt, starting at 1.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+
+	d := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// retryable reports whether err is worth another attempt.
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return service.IsTemporary(err)
+}
+
+// do calls send until it succeeds or the policy gives up, and returns the
+// number of attempts made along with the last error.
+func (p RetryPolicy) do(ctx context.Context, send func(context.Context) error) (int, error) {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := send(ctx)
+		if err == nil || attempt >= p.MaxAttempts || !p.retryable(err) {
+			return attempt, err
+		}
+
+		wait := p.backoff(attempt)
+		if p.MaxElapsed > 0 && time.Since(start)+wait > p.MaxElapsed {
+			return attempt, err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, err
+		case <-timer.C:
+		}
+	}
+}
+
+// middleware retries the sends of next according to the policy, and
+// records the number of attempts in the delivery's result.
+func (p RetryPolicy) middleware(next SendFunc) SendFunc {
+	return func(ctx context.Context, msg Message) error {
+		attempts, err := p.do(ctx, func(ctx context.Context) error {
+			return next(ctx, msg)
+		})
+		resultFrom(ctx).Attempts += attempts
+		return err
+	}
+}
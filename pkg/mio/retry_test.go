@@ -0,0 +1,102 @@
+package mio
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/phea/mio/pkg/service"
+)
+
+// TestRetryTemporary tests that temporary errors are retried and that the
+// attempts are reported.
+func TestRetryTemporary(t *testing.T) {
+	ts := newTestServer(withFailures(2, http.StatusBadGateway))
+	defer ts.Close()
+
+	var n Notifier
+	n.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+	n.Must("json://"+ts.host(), service.SetTLS(false))
+
+	res := n.BroadcastContext(context.Background(), Message{Title: "t"}).Results[0]
+	if res.Err != nil {
+		t.Errorf("expected no error, got %v", res.Err)
+	}
+	if res.Attempts != 3 || ts.hits() != 3 {
+		t.Errorf("expected 3 attempts, got %d (%d requests)", res.Attempts, ts.hits())
+	}
+}
+
+// TestRetryPermanent tests that errors not marked temporary are not
+// retried.
+func TestRetryPermanent(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	var n Notifier
+	n.Must("json://"+testHost(ts), service.SetTLS(false),
+		WithRetry(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}))
+
+	res := n.BroadcastContext(context.Background(), Message{Title: "t"}).Results[0]
+	var statusErr *service.StatusError
+	if !errors.As(res.Err, &statusErr) || statusErr.Code != http.StatusBadRequest {
+		t.Errorf("expected a 400 status error, got %v", res.Err)
+	}
+	if res.Attempts != 1 {
+		t.Errorf("expected 1 attempt, got %d", res.Attempts)
+	}
+}
+
+// TestRetryQueryParams tests that the retry policy can be set with query
+// parameters.
+func TestRetryQueryParams(t *testing.T) {
+	ts := newTestServer(withFailures(5, http.StatusBadGateway))
+	defer ts.Close()
+
+	var n Notifier
+	n.Must("json://"+ts.host()+"?retries=2&backoff=1ms", service.SetTLS(false))
+
+	res := n.BroadcastContext(context.Background(), Message{Title: "t"}).Results[0]
+	if res.Err == nil {
+		t.Errorf("expected an error")
+	}
+	if res.Attempts != 3 || ts.hits() != 3 {
+		t.Errorf("expected 3 attempts, got %d (%d requests)", res.Attempts, ts.hits())
+	}
+
+	if err := n.Add("json://localhost?retries=x"); err == nil {
+		t.Errorf("expected an error for invalid retries")
+	}
+}
+
+// TestRetryBackoff tests the exponential growth and the cap of the backoff.
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+	}
+
+	for _, test := range tests {
+		if got := p.backoff(test.attempt); got != test.want {
+			t.Errorf("expected backoff %v for attempt %d, got %v", test.want, test.attempt, got)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.backoff(1); got < 500*time.Millisecond || got > 1500*time.Millisecond {
+			t.Fatalf("expected jittered backoff within 50%%, got %v", got)
+		}
+	}
+}
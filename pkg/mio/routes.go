@@ -0,0 +1,199 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package mio
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/phea/mio/pkg/service"
+)
+
+var (
+	ErrRouteNotFound  = fmt.Errorf("route not found")
+	ErrDuplicateRoute = fmt.Errorf("route name already in use")
+)
+
+// RouteInfo describes a route of the Notifier.
+type RouteInfo struct {
+	// ID is the name of the route, see WithName.
+	ID     string
+	Scheme string
+	// URL is the route with credentials redacted.
+	URL  string
+	Tags []string
+	// Vars are the variables parsed from the route, with the values of
+	// the ones holding secrets masked.
+	Vars map[string]string
+	// Members describes the routes of a failover chain, in order.
+	Members []RouteInfo
+}
+
+// info returns the description of the route.
+func (r *route) info() RouteInfo {
+	if r.chain != nil {
+		info := RouteInfo{ID: r.name, Scheme: r.scheme, URL: r.chainRoute(), Tags: r.chainTags()}
+		for _, m := range r.chain {
+			info.Members = append(info.Members, m.info())
+		}
+		return info
+	}
+
+	vars := make(map[string]string, len(r.vars))
+	for k, v := range r.vars {
+		vars[k] = v
+	}
+	return RouteInfo{
+		ID:     r.name,
+		Scheme: r.scheme,
+		URL:    Redact(r.raw),
+		Tags:   append([]string(nil), r.tags...),
+		Vars:   vars,
+	}
+}
+
+// Routes returns the descriptions of the routes of the Notifier, in the
+// order they were added.
+func (n *Notifier) Routes() []RouteInfo {
+	routes, _ := n.snapshot()
+	infos := make([]RouteInfo, len(routes))
+	for i, r := range routes {
+		infos[i] = r.info()
+	}
+	return infos
+}
+
+// Remove removes the route with the given ID. Broadcasts in flight still
+// deliver to it, and its pending digest is sent in the background.
+func (n *Notifier) Remove(id string) error {
+	n.mu.Lock()
+	i := n.indexOf(id)
+	if i < 0 {
+		n.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrRouteNotFound, id)
+	}
+
+	old := n.routes[i]
+	routes := make([]*route, 0, len(n.routes)-1)
+	routes = append(routes, n.routes[:i]...)
+	n.routes = append(routes, n.routes[i+1:]...)
+	n.mu.Unlock()
+
+	n.retire(old)
+	return nil
+}
+
+// Replace replaces the route with the given ID by a new route, which takes
+// over its ID and position. Broadcasts in flight still deliver to the old
+// route, and its pending digest is sent in the background.
+func (n *Notifier) Replace(id string, rawRoute string, opts ...service.Option) error {
+	r, err := n.newRoute(rawRoute, opts...)
+	if err != nil {
+		return err
+	}
+	r.name = id
+
+	n.mu.Lock()
+	i := n.indexOf(id)
+	if i < 0 {
+		n.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrRouteNotFound, id)
+	}
+
+	old := n.routes[i]
+	if err := n.register(r, old); err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	routes := make([]*route, len(n.routes))
+	copy(routes, n.routes)
+	routes[i] = r
+	n.routes = routes
+	n.mu.Unlock()
+
+	n.retire(old)
+	return nil
+}
+
+// indexOf returns the position of the route with the given ID, -1 if there
+// is none. The caller must hold the lock.
+func (n *Notifier) indexOf(id string) int {
+	for i, r := range n.routes {
+		if r.name == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// register checks that the names of the route and its members are not in
+// use, ignoring the route being replaced, and names the unnamed ones after
+// their scheme, e.g. "json-2". The caller must hold the lock.
+func (n *Notifier) register(r *route, replaced *route) error {
+	routes := append([]*route{r}, r.chain...)
+	for _, m := range routes {
+		if m.name != "" && n.nameTaken(m.name, replaced) {
+			return fmt.Errorf("%w: %s", ErrDuplicateRoute, m.name)
+		}
+	}
+
+	if n.seq == nil {
+		n.seq = make(map[string]int)
+	}
+	for _, m := range routes {
+		for m.name == "" || n.nameTaken(m.name, replaced) {
+			n.seq[m.scheme]++
+			m.name = m.scheme + "-" + strconv.Itoa(n.seq[m.scheme])
+		}
+	}
+	return nil
+}
+
+// nameTaken reports whether a route or failover member other than the
+// ignored route and its members has the name. The caller must hold the
+// lock.
+func (n *Notifier) nameTaken(name string, ignored *route) bool {
+	for _, r := range n.routes {
+		if r == ignored {
+			continue
+		}
+		if r.name == name {
+			return true
+		}
+		for _, m := range r.chain {
+			if m.name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// retire sends the pending digests of a route that was removed.
+func (n *Notifier) retire(r *route) {
+	for _, m := range r.members() {
+		if m.digest == nil {
+			continue
+		}
+		m := m
+		// like the digests sent on a timer, the digest is not cancelled
+		// by Close, which waits for it.
+		started := n.goWorker(func(<-chan struct{}) {
+			n.flushDigest(context.Background(), m)
+		})
+		if !started {
+			// the Notifier is closed, there is no worker to wait for.
+			n.flushDigest(context.Background(), m)
+		}
+	}
+}
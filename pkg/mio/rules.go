@@ -0,0 +1,156 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package mio
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/phea/mio/pkg/service"
+)
+
+// Rule routes messages by severity: the messages whose severity satisfies
+// the condition go to the routes matching the selector.
+type Rule struct {
+	// Name identifies the rule in the broadcast reports.
+	Name string `json:"name" yaml:"name"`
+	// Severity is the condition on the message severity, such as
+	// ">= critical", "< warning" or "info". An empty condition matches all
+	// messages.
+	Severity string `json:"severity" yaml:"severity"`
+	// Routes is a tag expression selecting the routes, see ParseSelector.
+	Routes string `json:"routes" yaml:"routes"`
+}
+
+// LoadRules reads a JSON array of rules, e.g.
+//
+//	[{"name": "page", "severity": ">= critical", "routes": "sms || pagerduty"}]
+//
+// Rules may also be declared in the rules section of a config file, see
+// LoadConfig.
+func LoadRules(r io.Reader) ([]Rule, error) {
+	var rules []Rule
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("invalid rules: %w", err)
+	}
+	return rules, nil
+}
+
+// rule is a compiled Rule.
+type rule struct {
+	name   string
+	match  func(service.Severity) bool
+	routes Selector
+}
+
+// severityOps are the comparison operators of severity conditions, the
+// two character ones first so they are not mistaken for their prefix.
+var severityOps = []struct {
+	op  string
+	cmp func(a, b service.Severity) bool
+}{
+	{">=", func(a, b service.Severity) bool { return a >= b }},
+	{"<=", func(a, b service.Severity) bool { return a <= b }},
+	{"==", func(a, b service.Severity) bool { return a == b }},
+	{"!=", func(a, b service.Severity) bool { return a != b }},
+	{">", func(a, b service.Severity) bool { return a > b }},
+	{"<", func(a, b service.Severity) bool { return a < b }},
+}
+
+// parseSeverityCond parses a severity condition. The condition may start
+// with the word "severity", e.g. "severity >= critical".
+func parseSeverityCond(cond string) (func(service.Severity) bool, error) {
+	c := strings.TrimSpace(cond)
+	c = strings.TrimSpace(strings.TrimPrefix(c, "severity"))
+	if c == "" {
+		return func(service.Severity) bool { return true }, nil
+	}
+
+	cmp := func(a, b service.Severity) bool { return a == b }
+	for _, o := range severityOps {
+		if strings.HasPrefix(c, o.op) {
+			cmp = o.cmp
+			c = strings.TrimSpace(c[len(o.op):])
+			break
+		}
+	}
+
+	sev, err := service.ParseSeverity(c)
+	if err != nil {
+		return nil, fmt.Errorf("invalid severity condition %q: %w", cond, err)
+	}
+	return func(s service.Severity) bool { return cmp(s, sev) }, nil
+}
+
+func compileRule(r Rule) (rule, error) {
+	match, err := parseSeverityCond(r.Severity)
+	if err != nil {
+		return rule{}, fmt.Errorf("rule %q: %w", r.Name, err)
+	}
+
+	routes, err := ParseSelector(r.Routes)
+	if err != nil {
+		return rule{}, fmt.Errorf("rule %q: %w", r.Name, err)
+	}
+	return rule{name: r.Name, match: match, routes: routes}, nil
+}
+
+// SetRules replaces the routing rules of the Notifier. With rules set,
+// every message goes to the routes selected by the rules its severity
+// satisfies; messages no rule applies to go to every route. Routes carry
+// the implicit tag "scheme:<scheme>", so "scheme:gnome" selects the gnome
+// routes.
+func (n *Notifier) SetRules(rules ...Rule) error {
+	compiled := make([]rule, len(rules))
+	for i, r := range rules {
+		c, err := compileRule(r)
+		if err != nil {
+			return err
+		}
+		compiled[i] = c
+	}
+
+	n.mu.Lock()
+	n.rules = compiled
+	n.mu.Unlock()
+	return nil
+}
+
+// decide applies the rules to a message and a route. It reports whether
+// the route is notified, the name of the rule that selected it, and the
+// reason of the decision for the report.
+func decide(rules []rule, msg Message, tags []string) (bool, string, string) {
+	if len(rules) == 0 {
+		return true, "", ""
+	}
+
+	var applied []string
+	for _, r := range rules {
+		if !r.match(msg.Severity) {
+			continue
+		}
+		if r.routes.Match(tags) {
+			return true, r.name, fmt.Sprintf("selected by rule %q", r.name)
+		}
+		applied = append(applied, fmt.Sprintf("%q", r.name))
+	}
+
+	if len(applied) == 0 {
+		return true, "", fmt.Sprintf("no rule applies to severity %s", msg.Severity)
+	}
+	return false, "", fmt.Sprintf("not selected by rules %s for severity %s",
+		strings.Join(applied, ", "), msg.Severity)
+}
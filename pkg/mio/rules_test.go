@@ -0,0 +1,111 @@
+package mio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/phea/mio/pkg/service"
+)
+
+// TestParseSeverityCond tests the parsing of severity conditions.
+func TestParseSeverityCond(t *testing.T) {
+	tests := []struct {
+		cond     string
+		severity Severity
+		match    bool
+	}{
+		{"", SeverityDebug, true},
+		{">= critical", SeverityCritical, true},
+		{">= critical", SeverityError, false},
+		{"severity > warning", SeverityError, true},
+		{"< warning", SeverityInfo, true},
+		{"<= info", SeverityWarning, false},
+		{"info", SeverityInfo, true},
+		{"== info", SeverityDebug, false},
+		{"!= info", SeverityDebug, true},
+	}
+
+	for _, test := range tests {
+		match, err := parseSeverityCond(test.cond)
+		if err != nil {
+			t.Errorf("expected no error for %q, got %v", test.cond, err)
+			continue
+		}
+		if match(test.severity) != test.match {
+			t.Errorf("expected %q to match %s: %t", test.cond, test.severity, test.match)
+		}
+	}
+
+	if _, err := parseSeverityCond(">= severe"); err == nil {
+		t.Errorf("expected an error for an unknown severity")
+	}
+}
+
+// TestRules tests that messages are routed by severity and that the
+// decisions are reported.
+func TestRules(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		paths []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	rules, err := LoadRules(strings.NewReader(`[
+		{"name": "page", "severity": ">= critical", "routes": "pager"},
+		{"name": "desktop", "severity": "info", "routes": "scheme:xml"}
+	]`))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var n Notifier
+	if err := n.SetRules(rules...); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	n.Must("json://"+testHost(ts)+"/pager?tag=pager", service.SetTLS(false))
+	n.Must("xml://"+testHost(ts)+"/desktop", service.SetTLS(false))
+
+	report := n.BroadcastContext(context.Background(), Message{Title: "t", Severity: SeverityCritical})
+	if len(report.Sent()) != 1 || report.Results[0].Rule != "page" {
+		t.Errorf("expected only the pager to be selected by the page rule, got %+v", report.Results)
+	}
+	if !report.Results[1].Skipped || report.Results[1].Decision == "" {
+		t.Errorf("expected the desktop route to be skipped with a reason, got %+v", report.Results[1])
+	}
+
+	report = n.BroadcastContext(context.Background(), Message{Title: "t", Severity: SeverityInfo})
+	if len(report.Sent()) != 1 || report.Results[1].Rule != "desktop" {
+		t.Errorf("expected only the desktop to be selected, got %+v", report.Results)
+	}
+
+	report = n.BroadcastContext(context.Background(), Message{Title: "t", Severity: SeverityWarning})
+	if len(report.Sent()) != 2 {
+		t.Errorf("expected messages no rule applies to to go everywhere, got %+v", report.Results)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(paths) != 4 {
+		t.Errorf("expected 4 requests, got %v", paths)
+	}
+}
+
+// TestSetRulesInvalid tests that invalid rules are rejected.
+func TestSetRulesInvalid(t *testing.T) {
+	var n Notifier
+	if err := n.SetRules(Rule{Name: "bad", Severity: ">= critical", Routes: "a &&"}); err == nil {
+		t.Errorf("expected an error for an invalid selector")
+	}
+	if _, err := LoadRules(strings.NewReader(`[{"name": "x", "sevrity": "info"}]`)); err == nil {
+		t.Errorf("expected an error for an unknown field")
+	}
+}
@@ -0,0 +1,158 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package mio
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// SecretResolver resolves the placeholders of routes, e.g. "${SLACK_TOKEN}",
+// to the secrets they stand for.
+type SecretResolver interface {
+	// Resolve returns the secret referenced by the placeholder, given
+	// without the enclosing "${" and "}".
+	Resolve(ref string) (string, error)
+}
+
+// SecretResolverFunc is a function implementing SecretResolver.
+type SecretResolverFunc func(ref string) (string, error)
+
+func (f SecretResolverFunc) Resolve(ref string) (string, error) {
+	return f(ref)
+}
+
+// secretCommandTimeout is the time limit of the commands run by
+// CommandSecretResolver.
+const secretCommandTimeout = 10 * time.Second
+
+// DefaultSecretResolver resolves two kinds of placeholders:
+//
+//	${NAME} or ${env:NAME}  the environment variable NAME
+//	${file:PATH}            the content of the file at PATH
+//
+// Trailing newlines are removed from the contents. It does not run
+// commands, see CommandSecretResolver.
+var DefaultSecretResolver SecretResolver = SecretResolverFunc(func(ref string) (string, error) {
+	return resolveSecret(ref, false)
+})
+
+// CommandSecretResolver resolves the placeholders of DefaultSecretResolver
+// and a third kind:
+//
+//	${cmd:COMMAND ARGS}     the output of the command, run without a shell
+//
+// Trailing newlines are removed from the outputs. Since routes can then
+// run any program, set it with SetSecretResolver only on Notifiers whose
+// routes and config files are trusted.
+var CommandSecretResolver SecretResolver = SecretResolverFunc(func(ref string) (string, error) {
+	return resolveSecret(ref, true)
+})
+
+// resolveSecret resolves the placeholder, running commands only if cmd is
+// set.
+func resolveSecret(ref string, cmd bool) (string, error) {
+	kind, arg, ok := strings.Cut(ref, ":")
+	if !ok {
+		kind, arg = "env", ref
+	}
+
+	switch kind {
+	case "env":
+		v, ok := os.LookupEnv(arg)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", arg)
+		}
+		return v, nil
+	case "file":
+		data, err := os.ReadFile(arg)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case "cmd":
+		if !cmd {
+			return "", fmt.Errorf("command placeholders are disabled, see CommandSecretResolver")
+		}
+		args := strings.Fields(arg)
+		if len(args) == 0 {
+			return "", fmt.Errorf("empty command")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), secretCommandTimeout)
+		defer cancel()
+		out, err := exec.CommandContext(ctx, args[0], args[1:]...).Output()
+		if err != nil {
+			// the output is left out of the error, it may hold the secret.
+			return "", fmt.Errorf("command %s failed: %w", args[0], err)
+		}
+		return strings.TrimRight(string(out), "\r\n"), nil
+	}
+	return "", fmt.Errorf("unknown secret kind %q", kind)
+}
+
+// SetSecretResolver sets the resolver of the placeholders of the routes
+// added from then on, DefaultSecretResolver by default.
+func (n *Notifier) SetSecretResolver(r SecretResolver) {
+	n.mu.Lock()
+	n.resolver = r
+	n.mu.Unlock()
+}
+
+// secretResolver returns the resolver of the Notifier.
+func (n *Notifier) secretResolver() SecretResolver {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.resolver == nil {
+		return DefaultSecretResolver
+	}
+	return n.resolver
+}
+
+// expandSecrets replaces the placeholders of the route with the secrets
+// they stand for, and returns the secrets so that they can be masked.
+func expandSecrets(route string, r SecretResolver) (string, []string, error) {
+	var (
+		b       strings.Builder
+		secrets []string
+	)
+	rest := route
+	for {
+		start := strings.Index(rest, "${")
+		if start < 0 {
+			b.WriteString(rest)
+			break
+		}
+		end := strings.Index(rest[start:], "}")
+		if end < 0 {
+			return "", nil, fmt.Errorf("unterminated placeholder in route")
+		}
+
+		ref := rest[start+2 : start+end]
+		secret, err := r.Resolve(ref)
+		if err != nil {
+			return "", nil, fmt.Errorf("resolving ${%s}: %w", ref, err)
+		}
+		if secret != "" {
+			secrets = append(secrets, secret)
+		}
+
+		b.WriteString(rest[:start])
+		b.WriteString(secret)
+		rest = rest[start+end+1:]
+	}
+	return b.String(), secrets, nil
+}
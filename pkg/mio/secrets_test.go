@@ -0,0 +1,105 @@
+package mio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/phea/mio/pkg/service"
+)
+
+// TestResolveSecret tests the placeholders of the default resolver.
+func TestResolveSecret(t *testing.T) {
+	t.Setenv("MIO_TEST_TOKEN", "env-token")
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("file-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ref, secret string
+	}{
+		{"MIO_TEST_TOKEN", "env-token"},
+		{"env:MIO_TEST_TOKEN", "env-token"},
+		{"file:" + path, "file-token"},
+	}
+	for _, test := range tests {
+		for _, r := range []SecretResolver{DefaultSecretResolver, CommandSecretResolver} {
+			secret, err := r.Resolve(test.ref)
+			if err != nil || secret != test.secret {
+				t.Errorf("expected %s for %s, got %q, %v", test.secret, test.ref, secret, err)
+			}
+		}
+	}
+	if secret, err := CommandSecretResolver.Resolve("cmd:echo cmd-token"); err != nil || secret != "cmd-token" {
+		t.Errorf("expected cmd-token, got %q, %v", secret, err)
+	}
+
+	for _, ref := range []string{"MIO_TEST_UNSET", "file:/nonexistent", "cmd:echo cmd-token", "vault:x"} {
+		if _, err := DefaultSecretResolver.Resolve(ref); err == nil {
+			t.Errorf("expected an error for %s", ref)
+		}
+	}
+	if _, err := CommandSecretResolver.Resolve("cmd:"); err == nil {
+		t.Errorf("expected an error for an empty command")
+	}
+}
+
+// TestSecretRoutes tests that the placeholders are resolved when routes
+// are added, and that the secrets do not show in the routes and errors.
+func TestSecretRoutes(t *testing.T) {
+	const secret = "s3cr3t/t0ken"
+
+	var paths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	var n Notifier
+	n.SetSecretResolver(SecretResolverFunc(func(ref string) (string, error) {
+		if ref != "TOKEN" {
+			return "", fmt.Errorf("unknown secret")
+		}
+		return secret, nil
+	}))
+	n.Must("json://"+testHost(ts)+"/hooks/${TOKEN}?token=${TOKEN}&channel=a", service.SetTLS(false))
+
+	report := n.BroadcastContext(context.Background(), Message{Title: "title"})
+	if len(paths) != 1 || paths[0] != "/hooks/"+secret {
+		t.Fatalf("expected the secret to be sent to the service, got %v", paths)
+	}
+	if report.Err() == nil {
+		t.Fatalf("expected an error")
+	}
+	var status *service.StatusError
+	if !errors.As(report.Results[0].Err, &status) {
+		t.Errorf("expected the status error to be kept, got %v", report.Results[0].Err)
+	}
+
+	info := fmt.Sprintf("%+v", n.Routes())
+	for _, s := range []string{report.Err().Error(), report.Results[0].Route, info} {
+		if strings.Contains(s, "s3cr3t") {
+			t.Errorf("expected the secret to be masked in %q", s)
+		}
+	}
+	if !strings.Contains(info, "${TOKEN}") {
+		t.Errorf("expected the routes to show the placeholders, got %s", info)
+	}
+
+	for _, route := range []string{"json://localhost/${OTHER}", "json://localhost/${TOKEN"} {
+		err := n.Add(route)
+		if err == nil {
+			t.Errorf("expected an error for %s", route)
+		} else if strings.Contains(err.Error(), "s3cr3t") {
+			t.Errorf("expected the secret to be masked in %q", err)
+		}
+	}
+}
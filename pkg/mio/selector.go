@@ -0,0 +1,55 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package mio
+
+import (
+	"strings"
+
+	"github.com/phea/mio/internal/selector"
+)
+
+// Selector picks the routes a message is broadcast to by their tags.
+type Selector interface {
+	Match(tags []string) bool
+}
+
+// ParseSelector parses a tag expression such as "oncall && !desktop".
+// Tags are combined with && (and), || (or), ! (not) and parentheses. On top
+// of their own tags, routes carry the implicit tag "scheme:<scheme>".
+func ParseSelector(expr string) (Selector, error) {
+	s, err := selector.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MustSelector is like ParseSelector but panics if the expression is
+// invalid.
+func MustSelector(expr string) Selector {
+	s, err := ParseSelector(expr)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// splitTags splits a comma separated list of tags, dropping empty ones.
+func splitTags(s string) []string {
+	var tags []string
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,54 @@
+package mio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/phea/mio/pkg/service"
+)
+
+// TestBroadcastTo tests that only the routes matching the selector are
+// sent to.
+func TestBroadcastTo(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		paths []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	var n Notifier
+	n.Must("json://"+testHost(ts)+"/db", service.SetTLS(false), WithTags("oncall", "db"))
+	n.Must("json://"+testHost(ts)+"/desktop?tag=oncall,desktop", service.SetTLS(false))
+	n.Must("json://"+testHost(ts)+"/deploy?tag=deploy", service.SetTLS(false))
+
+	report := n.BroadcastTo(context.Background(), MustSelector("oncall && !desktop"), Message{Title: "t"})
+	if len(report.Results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(report.Results))
+	}
+	if err := report.Err(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(paths) != 1 || paths[0] != "/db" {
+		t.Errorf("expected only /db to be sent to, got %v", paths)
+	}
+
+	res := n.BroadcastTo(context.Background(), MustSelector("desktop"), Message{Title: "t"}).Results
+	if len(res) != 1 || len(res[0].Tags) != 2 || res[0].Tags[1] != "desktop" {
+		t.Errorf("expected the tags of the query to be parsed, got %+v", res)
+	}
+}
+
+// TestParseSelectorInvalid tests that invalid expressions are reported.
+func TestParseSelectorInvalid(t *testing.T) {
+	if _, err := ParseSelector("oncall &&"); err == nil {
+		t.Errorf("expected an error")
+	}
+}
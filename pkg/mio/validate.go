@@ -0,0 +1,313 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package mio
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/phea/mio/internal/matcher"
+	"github.com/phea/mio/pkg/service"
+)
+
+// Diagnostic tells whether a route is valid and, if not, why.
+type Diagnostic struct {
+	// Route is the route with credentials redacted.
+	Route  string
+	Scheme string
+	// KnownScheme is set when a service is registered for the scheme.
+	// Otherwise Suggestions lists the registered schemes close to it.
+	KnownScheme bool
+	Suggestions []string
+	// Template is the template of the scheme closest to the route. Part
+	// is where the route departs from it, a variable such as "{port}" or
+	// a separator such as "@", and Got what the route has in its place.
+	// Part is empty if the route matches the template, or goes on past
+	// its end.
+	Template string
+	Part     string
+	Got      string
+	// UnknownParams are the query parameters of the route neither mio nor
+	// the service knows, and MissingParams the ones the service requires
+	// that the route lacks.
+	UnknownParams []string
+	MissingParams []string
+	// Problems describes every problem found, one sentence each.
+	Problems []string
+
+	matched bool // the route matches a template of the scheme
+}
+
+// OK reports whether no problem was found.
+func (d Diagnostic) OK() bool {
+	return len(d.Problems) == 0
+}
+
+// Err returns a RouteError describing the problems, nil if there are
+// none.
+func (d Diagnostic) Err() error {
+	if d.OK() {
+		return nil
+	}
+	return &RouteError{Diagnostic: d}
+}
+
+func (d *Diagnostic) problem(format string, args ...interface{}) {
+	d.Problems = append(d.Problems, fmt.Sprintf(format, args...))
+}
+
+// RouteError is the error of an invalid route. It wraps
+// ErrServiceNotFound if the route matches none of the templates of the
+// registered services.
+type RouteError struct {
+	Diagnostic Diagnostic
+}
+
+func (e *RouteError) Error() string {
+	return fmt.Sprintf("invalid route %s: %s", e.Diagnostic.Route, strings.Join(e.Diagnostic.Problems, "; "))
+}
+
+func (e *RouteError) Unwrap() error {
+	if e.Diagnostic.matched {
+		return nil
+	}
+	return ErrServiceNotFound
+}
+
+// Validate checks the route against the services of the default
+// registry, see Registry.Validate.
+func Validate(route string) Diagnostic {
+	return defaultRegistry.Validate(route)
+}
+
+// Validate checks the route against the registered services, without
+// initializing one: its scheme, the template of the scheme it is closest
+// to, and its query parameters, which are checked against the ones of mio
+// and the ones listed by the service's spec. Validate is stricter than
+// Notifier.Add, which ignores unknown parameters. Values holding ${...}
+// placeholders are not checked.
+func (r *Registry) Validate(route string) Diagnostic {
+	d := Diagnostic{Route: Redact(route)}
+
+	scheme, _, ok := strings.Cut(route, "://")
+	if !ok {
+		d.problem(`missing "://" after the scheme`)
+		return d
+	}
+	d.Scheme = scheme
+
+	spec, matchers := r.lookup(scheme)
+	if matchers == nil {
+		d.Suggestions = closest(scheme, r.Schemes())
+		if len(d.Suggestions) > 0 {
+			d.problem("unknown scheme %q, did you mean %q?", scheme, d.Suggestions[0])
+		} else {
+			d.problem("unknown scheme %q", scheme)
+		}
+		return d
+	}
+	d.KnownScheme = true
+
+	d.explain(route, matchers)
+	d.checkParams(route, spec)
+	if d.matched && d.OK() && !strings.Contains(route, "${") {
+		// the parts the templates do not cover, such as the parameters of
+		// mio and the port of URLs, are only checked when parsed.
+		m, _ := r.match(route)
+		vars, err := m.matcher.Vars(route)
+		if err == nil {
+			cfg, _ := newRouteConfig(nil)
+			err = cfg.parseParams(vars)
+		}
+		if err != nil {
+			d.problem("%v", err)
+		}
+	}
+	return d
+}
+
+// lookup returns the spec and the matchers of the scheme, nil if it is
+// not registered.
+func (r *Registry) lookup(scheme string) (service.Spec, []serviceMatch) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var matchers []serviceMatch
+	for _, sm := range r.matchers {
+		if sm.matcher.Scheme() == scheme {
+			matchers = append(matchers, sm)
+		}
+	}
+	return r.specs[scheme], matchers
+}
+
+// explain finds the template closest to the route and where the route
+// departs from it. Routes matching the shape of several templates are
+// explained by the one with the fewest invalid variables, the most
+// specific one among them. Other routes are explained by the template
+// they follow the furthest, the least specific one among them.
+func (d *Diagnostic) explain(route string, matchers []serviceMatch) {
+	var (
+		best matcher.Mismatch
+		tmpl string
+	)
+	for _, sm := range matchers {
+		mm := sm.matcher.Explain(route)
+		switch {
+		case tmpl == "",
+			mm.Shape && !best.Shape,
+			mm.Shape && best.Shape && mm.Invalid <= best.Invalid,
+			!mm.Shape && !best.Shape && mm.Matched > best.Matched:
+			best, tmpl = mm, sm.matcher.Template()
+		}
+	}
+
+	d.Template, d.matched = tmpl, best.OK()
+	switch {
+	case best.OK():
+	case best.Shape:
+		d.Part, d.Got = "{"+best.Var+"}", best.Got
+		if isSecretVar(best.Var) {
+			d.Got = "xxxxx"
+		}
+		d.problem("invalid %s %q for template %s", d.Part, d.Got, tmpl)
+	case best.Literal != "":
+		d.Part, d.Got = best.Literal, best.Got
+		d.problem("expected %q at %q for template %s", d.Part, d.Got, tmpl)
+	default:
+		d.Got = best.Got
+		d.problem("unexpected %q after template %s", d.Got, tmpl)
+	}
+}
+
+// checkParams checks the query parameters of the route against the ones
+// of mio and of the service.
+func (d *Diagnostic) checkParams(route string, spec service.Spec) {
+	_, query, _ := strings.Cut(route, "?")
+	query, _, _ = strings.Cut(query, "#")
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		d.problem("invalid query: %v", err)
+		return
+	}
+
+	known := append(append(append([]string(nil), routeParams...), spec.Params...), spec.Required...)
+	for k := range q {
+		if !contains(known, k) {
+			d.UnknownParams = append(d.UnknownParams, k)
+		}
+	}
+	sort.Strings(d.UnknownParams)
+	for _, k := range d.UnknownParams {
+		if s := closest(k, known); len(s) > 0 {
+			d.problem("unknown parameter %q, did you mean %q?", k, s[0])
+		} else {
+			d.problem("unknown parameter %q", k)
+		}
+	}
+
+	for _, k := range spec.Required {
+		if _, ok := q[k]; !ok {
+			d.MissingParams = append(d.MissingParams, k)
+			d.problem("missing parameter %q", k)
+		}
+	}
+}
+
+// diagnose returns the error of a route the registry failed to add with
+// err: a RouteError if the route matches none of the templates, err
+// otherwise.
+func (r *Registry) diagnose(route string, err error) error {
+	if d := r.Validate(route); !d.matched {
+		return d.Err()
+	}
+	return err
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// closest returns the candidates close enough to s to be what was meant
+// by it, closest first: within one edit, plus one for every three
+// characters past the first three.
+func closest(s string, candidates []string) []string {
+	type match struct {
+		s    string
+		dist int
+	}
+	limit := 1
+	if n := len(s) / 3; n > limit {
+		limit = n
+	}
+
+	var matches []match
+	for _, c := range candidates {
+		if dist := editDistance(s, c); dist <= limit {
+			matches = append(matches, match{c, dist})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].dist < matches[j].dist
+	})
+
+	var out []string
+	for _, m := range matches {
+		out = append(out, m.s)
+	}
+	return out
+}
+
+// editDistance returns the number of single character insertions,
+// deletions, substitutions and transpositions of adjacent characters
+// turning a into b, a Damerau-Levenshtein distance.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+func minInt(v int, vs ...int) int {
+	for _, w := range vs {
+		if w < v {
+			v = w
+		}
+	}
+	return v
+}
@@ -0,0 +1,116 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package miotest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/phea/mio/pkg/mio"
+	"github.com/phea/mio/pkg/service"
+)
+
+// ErrNoRecorder is returned by the mem:// routes whose name has no
+// Recorder, see Mem.
+var ErrNoRecorder = fmt.Errorf("no recorder for mem route")
+
+var memTemplates = []string{
+	"mem://{name}",
+}
+
+// memSpec describes the mem service, registered with mio when the
+// package is imported, so that only test binaries accept mem:// routes.
+func memSpec() service.Spec {
+	return service.Spec{
+		Template: memTemplates,
+		Init: func() service.Service {
+			return &memService{logger: service.NopLogger{}}
+		},
+	}
+}
+
+func init() {
+	if err := mio.Register(memSpec()); err != nil {
+		panic(err)
+	}
+}
+
+// recorders holds the Recorders of the mem:// routes, by name.
+var recorders = struct {
+	sync.RWMutex
+	m map[string]*Recorder
+}{m: make(map[string]*Recorder)}
+
+// check if memService implements Service interface
+var _ service.Service = (*memService)(nil)
+
+// memService hands the messages over to the Recorder of its name, in
+// memory, instead of sending them anywhere.
+type memService struct {
+	vars   service.Vars
+	logger service.Logger
+}
+
+// Init initializes the service with the given Service Options.
+func (s *memService) Init(route string, vars service.Vars, opts ...service.Option) error {
+	s.vars = vars
+	for _, opt := range opts {
+		opt(s)
+	}
+	return nil
+}
+
+// Send passes the message to the Recorder of the route's name. It fails
+// if the name has none, so that a message is never reported as delivered
+// without being recorded.
+func (s *memService) Send(ctx context.Context, msg mio.Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	name := s.vars["name"]
+	recorders.RLock()
+	rec := recorders.m[name]
+	recorders.RUnlock()
+
+	if rec == nil {
+		return fmt.Errorf("%w: mem://%s", ErrNoRecorder, name)
+	}
+	s.logger.Debug("message recorded", "scheme", "mem", "name", name)
+	return rec.receive(ctx, msg)
+}
+
+// Preview returns the message as the JSON document handed to the
+// Recorder.
+func (s *memService) Preview(msg mio.Message) (service.Preview, error) {
+	data, err := json.MarshalIndent(msg, "", "  ")
+	if err != nil {
+		return service.Preview{}, err
+	}
+	return service.Preview{
+		Method:   "MEM",
+		Endpoint: "mem://" + s.vars["name"],
+		Body:     string(data),
+	}, nil
+}
+
+// SetOption sets the option for the service.
+func (s *memService) SetOption(key string, value interface{}) {
+	switch key {
+	case "logger":
+		if l, ok := value.(service.Logger); ok {
+			s.logger = l
+		}
+	}
+}
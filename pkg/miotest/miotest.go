@@ -0,0 +1,249 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+// Package miotest provides fake services and assertion helpers to test
+// code that sends notifications with mio, without network access.
+//
+// A Recorder receives the messages sent to the mem:// routes of a name,
+// see Mem, or every message sent by a Notifier in capture mode, see
+// Capture. Faults can be injected into the sends it receives.
+package miotest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/phea/mio/pkg/mio"
+	"github.com/phea/mio/pkg/service"
+)
+
+// Sent is a send received by a Recorder.
+type Sent struct {
+	// Route is the route the message was sent to, with credentials
+	// redacted, and Name its ID.
+	Route string
+	Name  string
+	// Message is the message as it reached the Recorder, after the
+	// middlewares.
+	Message mio.Message
+	// Err is the error the send failed with, nil if it succeeded.
+	Err error
+}
+
+// Recorder records the messages it receives, failing or delaying the
+// sends as told. It is safe for concurrent use.
+type Recorder struct {
+	mu     sync.Mutex
+	sends  []Sent
+	calls  int
+	faults map[int]error // by send number, starting at 1
+	fail   error
+	delay  time.Duration
+}
+
+// NewRecorder returns an empty Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{faults: make(map[int]error)}
+}
+
+// Mem returns a Recorder receiving the messages sent to the mem://name
+// routes until the end of the test. Names are made of letters, digits and
+// underscores. They are global, so tests running in parallel must use
+// different ones. Sends to a name without a Recorder fail with
+// ErrNoRecorder.
+//
+// The mem service is registered with mio's default registry when the
+// package is imported.
+func Mem(t testing.TB, name string) *Recorder {
+	rec := NewRecorder()
+	recorders.Lock()
+	recorders.m[name] = rec
+	recorders.Unlock()
+
+	t.Cleanup(func() {
+		recorders.Lock()
+		if recorders.m[name] == rec {
+			delete(recorders.m, name)
+		}
+		recorders.Unlock()
+	})
+	return rec
+}
+
+// Capture puts the Notifier in capture mode: the messages sent to its
+// routes are received by the returned Recorder instead of their services,
+// after the middlewares, retries and rate limits of the routes, see
+// Notifier.SetTransport. The faults it injects go through the retries.
+func Capture(n *mio.Notifier) *Recorder {
+	rec := NewRecorder()
+	n.SetTransport(rec.receive)
+	return rec
+}
+
+// NewNotifier returns a Notifier in capture mode, closed at the end of
+// the test, and its Recorder.
+func NewNotifier(t testing.TB) (*mio.Notifier, *Recorder) {
+	n := &mio.Notifier{}
+	rec := Capture(n)
+	t.Cleanup(func() { n.Close(context.Background()) })
+	return n, rec
+}
+
+// receive records a send, applying the faults.
+func (r *Recorder) receive(ctx context.Context, msg mio.Message) error {
+	r.mu.Lock()
+	r.calls++
+	err, ok := r.faults[r.calls]
+	if !ok {
+		err = r.fail
+	}
+	delay := r.delay
+	r.mu.Unlock()
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			err = ctx.Err()
+		}
+	}
+
+	sent := Sent{Message: msg, Err: err}
+	if info, ok := mio.RouteFrom(ctx); ok {
+		sent.Route, sent.Name = info.URL, info.ID
+	}
+
+	r.mu.Lock()
+	r.sends = append(r.sends, sent)
+	r.mu.Unlock()
+	return err
+}
+
+// FailNth makes the nth send received fail with err, counting from 1.
+func (r *Recorder) FailNth(n int, err error) {
+	r.mu.Lock()
+	r.faults[n] = err
+	r.mu.Unlock()
+}
+
+// FailAll makes every send fail with err, except the ones set by
+// FailNth. A nil error makes them succeed again.
+func (r *Recorder) FailAll(err error) {
+	r.mu.Lock()
+	r.fail = err
+	r.mu.Unlock()
+}
+
+// Delay makes every send wait for d, or for its context to be done.
+func (r *Recorder) Delay(d time.Duration) {
+	r.mu.Lock()
+	r.delay = d
+	r.mu.Unlock()
+}
+
+// Reset forgets the sends received so far and the faults.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sends, r.calls = nil, 0
+	r.faults = make(map[int]error)
+	r.fail, r.delay = nil, 0
+}
+
+// Sent returns the sends that succeeded, in the order they were received.
+func (r *Recorder) Sent() []Sent {
+	var sent []Sent
+	for _, s := range r.Attempts() {
+		if s.Err == nil {
+			sent = append(sent, s)
+		}
+	}
+	return sent
+}
+
+// Attempts returns every send received, including the failed ones.
+func (r *Recorder) Attempts() []Sent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Sent(nil), r.sends...)
+}
+
+// To returns the sends to the route that succeeded. The route is given
+// by ID or by URL, an empty one matches every route.
+func (r *Recorder) To(route string) []Sent {
+	var sent []Sent
+	for _, s := range r.Sent() {
+		if route == "" || route == s.Name || route == s.Route {
+			sent = append(sent, s)
+		}
+	}
+	return sent
+}
+
+// AssertSent fails the test unless a message whose title contains
+// titleContains was sent to the route, see To.
+func (r *Recorder) AssertSent(t testing.TB, route, titleContains string) {
+	t.Helper()
+
+	sent := r.To(route)
+	for _, s := range sent {
+		if strings.Contains(s.Message.Title, titleContains) {
+			return
+		}
+	}
+	t.Errorf("expected a message titled %q sent to %q, got %s", titleContains, route, titles(sent))
+}
+
+// AssertNotSent fails the test if any message was sent to the route, see
+// To.
+func (r *Recorder) AssertNotSent(t testing.TB, route string) {
+	t.Helper()
+
+	if sent := r.To(route); len(sent) > 0 {
+		t.Errorf("expected no message sent to %q, got %s", route, titles(sent))
+	}
+}
+
+// AssertCount fails the test unless count messages were sent to the
+// route, see To.
+func (r *Recorder) AssertCount(t testing.TB, route string, count int) {
+	t.Helper()
+
+	if sent := r.To(route); len(sent) != count {
+		t.Errorf("expected %d messages sent to %q, got %d: %s", count, route, len(sent), titles(sent))
+	}
+}
+
+// titles lists the titles of the messages for the failure reports.
+func titles(sent []Sent) string {
+	if len(sent) == 0 {
+		return "none"
+	}
+	quoted := make([]string, len(sent))
+	for i, s := range sent {
+		quoted[i] = fmt.Sprintf("%q", s.Message.Title)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// Status returns the error of an HTTP based service receiving the status
+// code, temporary for 429 and 5xx codes.
+func Status(code int) error {
+	return &service.StatusError{Code: code, Status: fmt.Sprintf("%d %s", code, http.StatusText(code))}
+}
@@ -0,0 +1,119 @@
+package miotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/phea/mio/pkg/mio"
+	"github.com/phea/mio/pkg/service"
+)
+
+// fakeT records the failures of the assertions under test.
+type fakeT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+// TestMem tests that the messages sent to mem:// routes are recorded, and
+// that injected faults go through the route's retries.
+func TestMem(t *testing.T) {
+	rec := Mem(t, "test_mem")
+	rec.FailNth(1, Status(503))
+
+	var n mio.Notifier
+	n.Must("mem://test_mem", mio.WithName("alerts"), mio.WithRetry(mio.RetryPolicy{MaxAttempts: 2}))
+	n.Must("mem://elsewhere")
+
+	report := n.BroadcastContext(context.Background(), mio.Message{Title: "disk full"})
+	if err := report.Results[0].Err; err != nil {
+		t.Fatalf("expected the retry to succeed, got %v", err)
+	}
+	if err := report.Results[1].Err; !errors.Is(err, ErrNoRecorder) {
+		t.Errorf("expected a route without recorder to fail, got %v", err)
+	}
+
+	attempts := rec.Attempts()
+	if len(attempts) != 2 || !service.IsTemporary(attempts[0].Err) || attempts[1].Err != nil {
+		t.Fatalf("expected a failed attempt then a successful one, got %+v", attempts)
+	}
+	rec.AssertSent(t, "alerts", "disk")
+	rec.AssertSent(t, "mem://test_mem", "full")
+	rec.AssertCount(t, "", 1)
+
+	ft := &fakeT{}
+	rec.AssertSent(ft, "alerts", "cpu")
+	rec.AssertNotSent(ft, "alerts")
+	rec.AssertCount(ft, "alerts", 2)
+	if len(ft.errors) != 3 {
+		t.Errorf("expected the assertions to fail, got %v", ft.errors)
+	}
+}
+
+// TestCapture tests that a Notifier in capture mode records the messages
+// instead of sending them.
+func TestCapture(t *testing.T) {
+	n, rec := NewNotifier(t)
+	n.Must("json://localhost:1/hook", mio.WithName("hook"), service.SetTLS(false))
+	n.Must("gnome://mio", mio.WithName("desktop"))
+
+	errDown := errors.New("down")
+	rec.FailAll(errDown)
+	rec.FailNth(1, nil)
+
+	report := n.BroadcastContext(context.Background(), mio.Message{Title: "deployed"})
+	if len(report.Failed()) != 1 || !errors.Is(report.Failed()[0].Err, errDown) {
+		t.Fatalf("expected one send to fail, got %+v", report.Results)
+	}
+	rec.AssertCount(t, "", 1)
+
+	rec.Reset()
+	n.BroadcastContext(context.Background(), mio.Message{Title: "deployed"})
+	rec.AssertSent(t, "hook", "deployed")
+	rec.AssertSent(t, "desktop", "deployed")
+}
+
+// TestDelay tests that delayed sends are abandoned when their context is
+// done.
+func TestDelay(t *testing.T) {
+	n, rec := NewNotifier(t)
+	n.Must("mem://test_delay")
+	rec.Delay(time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	report := n.BroadcastContext(ctx, mio.Message{Title: "slow"})
+	if err := report.Results[0].Err; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+	rec.AssertNotSent(t, "")
+}
+
+// TestCaptureMiddleware tests that the messages are captured after the
+// middlewares, including the ones added after Capture.
+func TestCaptureMiddleware(t *testing.T) {
+	n, rec := NewNotifier(t)
+	prefix := func(next mio.SendFunc) mio.SendFunc {
+		return func(ctx context.Context, msg mio.Message) error {
+			msg.Title = "[prod] " + msg.Title
+			return next(ctx, msg)
+		}
+	}
+	n.Use(prefix)
+	n.Must("gnome://mio", mio.WithName("desktop"), mio.WithMiddleware(prefix))
+
+	n.BroadcastContext(context.Background(), mio.Message{Title: "hi"})
+	sent := rec.Sent()
+	if len(sent) != 1 || sent[0].Message.Title != "[prod] [prod] hi" {
+		t.Errorf("expected the title set by the middlewares, got %+v", sent)
+	}
+}
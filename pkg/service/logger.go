@@ -0,0 +1,37 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package service
+
+// Logger is a structured logger. The args are alternating keys and values,
+// as taken by the methods of log/slog's Logger, which implements it.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// NopLogger is a Logger discarding everything.
+type NopLogger struct{}
+
+func (NopLogger) Debug(msg string, args ...interface{}) {}
+func (NopLogger) Info(msg string, args ...interface{})  {}
+func (NopLogger) Warn(msg string, args ...interface{})  {}
+func (NopLogger) Error(msg string, args ...interface{}) {}
+
+// SetLogger sets the logger of the service. Services log nothing by
+// default.
+func SetLogger(l Logger) Option {
+	return func(s Service) {
+		s.SetOption("logger", l)
+	}
+}
@@ -0,0 +1,113 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Priority tells how urgently a message should get the attention of its
+// recipient. The zero value is PriorityNormal.
+type Priority int
+
+const (
+	PriorityLow Priority = iota - 1
+	PriorityNormal
+	PriorityHigh
+	PriorityUrgent
+)
+
+var priorityNames = map[Priority]string{
+	PriorityLow:    "low",
+	PriorityNormal: "normal",
+	PriorityHigh:   "high",
+	PriorityUrgent: "urgent",
+}
+
+func (p Priority) String() string {
+	if name, ok := priorityNames[p]; ok {
+		return name
+	}
+	return "Priority(" + strconv.Itoa(int(p)) + ")"
+}
+
+// ParsePriority parses the name of a priority, e.g. "high".
+func ParsePriority(s string) (Priority, error) {
+	for p, name := range priorityNames {
+		if strings.EqualFold(s, name) {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid priority %q", s)
+}
+
+// Severity tells how serious the event described by a message is.
+// Severities are ordered, so they can be compared. The zero value is
+// SeverityInfo.
+type Severity int
+
+const (
+	SeverityDebug Severity = iota - 1
+	SeverityInfo
+	SeverityWarning
+	SeverityError
+	SeverityCritical
+)
+
+var severityNames = map[Severity]string{
+	SeverityDebug:    "debug",
+	SeverityInfo:     "info",
+	SeverityWarning:  "warning",
+	SeverityError:    "error",
+	SeverityCritical: "critical",
+}
+
+func (s Severity) String() string {
+	if name, ok := severityNames[s]; ok {
+		return name
+	}
+	return "Severity(" + strconv.Itoa(int(s)) + ")"
+}
+
+// ParseSeverity parses the name of a severity, e.g. "critical".
+func ParseSeverity(s string) (Severity, error) {
+	for sev, name := range severityNames {
+		if strings.EqualFold(s, name) {
+			return sev, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid severity %q", s)
+}
+
+// Message is a notification. Services map the fields they support and
+// ignore the others, the title and body are supported by all of them.
+type Message struct {
+	Title    string   `json:"title"`
+	Body     string   `json:"body"`
+	Priority Priority `json:"priority,omitempty"`
+	Severity Severity `json:"severity,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	// URL is opened when the recipient clicks through the notification.
+	URL string `json:"url,omitempty"`
+	// ImageURL is an image shown along with the notification.
+	ImageURL  string    `json:"image_url,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	// Extras holds free-form fields passed on as-is by the services that
+	// support them.
+	Extras map[string]string `json:"extras,omitempty"`
+	// DedupKey identifies repeats of the message for duplicate
+	// suppression, in place of its title and body. Services ignore it.
+	DedupKey string `json:"dedup_key,omitempty"`
+}
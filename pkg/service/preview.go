@@ -0,0 +1,63 @@
+/*
+ * BSD 3-Clause License
+ *
+ * Copyright (c) 2023, Phea Duch <phea.duch@gmail.com>
+ * All rights reserved.
+ *
+ * Use of this source code is governed by a BSD-style license
+ * that can be found in the LICENSE file.
+ *
+ */
+
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Preview is what a service would send for a message.
+type Preview struct {
+	// Method is the HTTP method of the request, or the protocol for
+	// services that are not HTTP based, e.g. "SMTP" or "D-Bus".
+	Method string
+	// Endpoint is the URL of the request, the mail server, or the D-Bus
+	// method called, with passwords masked.
+	Endpoint string
+	Headers  map[string]string
+	Body     string
+}
+
+// Previewer is implemented by the services that can build what they would
+// send for a message without sending it.
+type Previewer interface {
+	Preview(msg Message) (Preview, error)
+}
+
+// previewRequest returns the preview of an HTTP request, reading its body.
+func previewRequest(req *http.Request) (Preview, error) {
+	u := *req.URL
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+
+	p := Preview{
+		Method:   req.Method,
+		Endpoint: u.String(),
+		Headers:  make(map[string]string, len(req.Header)),
+	}
+	for k := range req.Header {
+		p.Headers[k] = strings.Join(req.Header.Values(k), ", ")
+	}
+
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return Preview{}, err
+		}
+		p.Body = string(body)
+	}
+	return p, nil
+}
@@ -11,6 +11,15 @@
 
 package service
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
 var specs = []Spec{}
 
 type Vars map[string]string
@@ -18,15 +27,69 @@ type Vars map[string]string
 type Service interface {
 	// Init initializes the service with the given Service Options.
 	Init(route string, vars Vars, opts ...Option) error
-	// Parse parses the route.
-	Send(title, body string) error
+	// Send sends the message, aborting once ctx is done.
+	Send(ctx context.Context, msg Message) error
 	// SetOption sets the option for the service.
 	SetOption(key string, value interface{})
 }
 
+// LegacyService is a service implementing the title and body contract
+// that predates Message. Use Adapt to turn it into a Service.
+type LegacyService interface {
+	Init(route string, vars Vars, opts ...Option) error
+	Send(title, body string) error
+	SetOption(key string, value interface{})
+}
+
+// Adapt returns a Service sending the title and body of messages through
+// the legacy service. The options given to Init are applied through the
+// legacy service's SetOption.
+func Adapt(s LegacyService) Service {
+	return &legacyAdapter{legacy: s}
+}
+
+type legacyAdapter struct {
+	legacy LegacyService
+}
+
+func (a *legacyAdapter) Init(route string, vars Vars, opts ...Option) error {
+	if err := a.legacy.Init(route, vars); err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return nil
+}
+
+func (a *legacyAdapter) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.legacy.Send(msg.Title, msg.Body)
+}
+
+// SetOption passes the option on to the legacy service, except for the
+// logger which legacy services predate.
+func (a *legacyAdapter) SetOption(key string, value interface{}) {
+	if key == "logger" {
+		return
+	}
+	a.legacy.SetOption(key, value)
+}
+
+// Spec describes a service: the route templates it handles and a factory
+// returning a new, uninitialized instance of it.
+// Services outside of this package are made available to the routes with
+// mio.Register.
 type Spec struct {
 	Template []string
 	Init     func() Service
+	// Params are the query parameters the routes of the service may have
+	// and Required the ones they must have, which need not be listed in
+	// Params. They are used to diagnose routes, see mio.Validate.
+	Params   []string
+	Required []string
 }
 
 func Specs() []Spec {
@@ -35,8 +98,114 @@ func Specs() []Spec {
 
 type Option func(Service)
 
+// DefaultTimeout is the time limit of the requests made by HTTP based
+// services unless set with SetTimeout.
+const DefaultTimeout = 30 * time.Second
+
 func SetTLS(isTLS bool) Option {
 	return func(s Service) {
 		s.SetOption("isTLS", isTLS)
 	}
 }
+
+// SetTimeout sets the time limit of the requests made by HTTP based
+// services.
+func SetTimeout(d time.Duration) Option {
+	return func(s Service) {
+		s.SetOption("timeout", d)
+	}
+}
+
+// SetMethod sets the HTTP method of the requests made by HTTP based
+// services.
+func SetMethod(method string) Option {
+	return func(s Service) {
+		s.SetOption("method", method)
+	}
+}
+
+// StatusError is returned by HTTP based services when the endpoint responds
+// with a status code outside of the 2xx range.
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected response status: %s", e.Status)
+}
+
+// Temporary reports whether the request may succeed if retried, which is
+// the case for rate limiting and server errors.
+func (e *StatusError) Temporary() bool {
+	return e.Code == 429 || e.Code >= 500
+}
+
+// checkStatus returns a StatusError if the response status is not 2xx.
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+	return nil
+}
+
+// temporaryError marks the error it wraps as temporary.
+type temporaryError struct {
+	err error
+}
+
+func (e *temporaryError) Error() string   { return e.err.Error() }
+func (e *temporaryError) Unwrap() error   { return e.err }
+func (e *temporaryError) Temporary() bool { return true }
+
+// Temporary wraps err so that IsTemporary reports true for it.
+func Temporary(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &temporaryError{err: err}
+}
+
+// IsTemporary reports whether err, or any error it wraps, is marked as
+// temporary or is a network timeout.
+func IsTemporary(err error) bool {
+	var tmp interface{ Temporary() bool }
+	if errors.As(err, &tmp) && tmp.Temporary() {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// permanentError marks the error it wraps as permanent.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string   { return e.err.Error() }
+func (e *permanentError) Unwrap() error   { return e.err }
+func (e *permanentError) Permanent() bool { return true }
+
+// Permanent wraps err so that IsPermanent reports true for it.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err, or any error it wraps, is marked as
+// permanent or is a StatusError of a 4xx status other than 408 and 429:
+// the message will never be accepted as is. Errors that are neither
+// temporary nor permanent, such as refused connections, may succeed later.
+func IsPermanent(err error) bool {
+	var perm interface{ Permanent() bool }
+	if errors.As(err, &perm) && perm.Permanent() {
+		return true
+	}
+
+	var se *StatusError
+	return errors.As(err, &se) && se.Code >= 400 && se.Code < 500 &&
+		se.Code != 408 && se.Code != 429
+}
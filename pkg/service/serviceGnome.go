@@ -12,7 +12,9 @@
 package service
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/esiqveland/notify"
@@ -35,6 +37,28 @@ type gnomePayload struct {
 	icon    string
 	title   string
 	body    string
+	urgency gnomeUrgency
+	image   string
+}
+
+// gnomeUrgency is the urgency level of the desktop notification spec.
+type gnomeUrgency byte
+
+const (
+	urgencyLow gnomeUrgency = iota
+	urgencyNormal
+	urgencyCritical
+)
+
+// urgencyOf maps the priority of a message to a notification urgency.
+func urgencyOf(p Priority) gnomeUrgency {
+	switch {
+	case p < PriorityNormal:
+		return urgencyLow
+	case p > PriorityNormal:
+		return urgencyCritical
+	}
+	return urgencyNormal
 }
 
 type senderFunc func(payload gnomePayload) error
@@ -44,6 +68,7 @@ type ServiceGnome struct {
 	icon   string
 	vars   Vars
 	sender senderFunc
+	logger Logger
 }
 
 func gnomeSpec() Spec {
@@ -52,6 +77,7 @@ func gnomeSpec() Spec {
 		Init: func() Service {
 			return defaultGnomeService()
 		},
+		Params: []string{"name", "icon"},
 	}
 }
 
@@ -71,18 +97,30 @@ func defaultSender(payload gnomePayload) error {
 	if err = conn.Hello(); err != nil {
 		return err
 	}
-	notification := notify.Notification{
+
+	if _, err := notify.SendNotification(conn, newNotification(payload)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// newNotification returns the desktop notification of the payload.
+func newNotification(payload gnomePayload) notify.Notification {
+	hints := map[string]dbus.Variant{
+		"urgency": dbus.MakeVariant(byte(payload.urgency)),
+	}
+	if payload.image != "" {
+		hints["image-path"] = dbus.MakeVariant(payload.image)
+	}
+
+	return notify.Notification{
 		AppName:       payload.appName,
 		AppIcon:       payload.icon,
 		Summary:       payload.title,
 		Body:          payload.body,
+		Hints:         hints,
 		ExpireTimeout: time.Second * 5,
 	}
-
-	if _, err := notify.SendNotification(conn, notification); err != nil {
-		return err
-	}
-	return nil
 }
 
 func defaultGnomeService() *ServiceGnome {
@@ -90,6 +128,7 @@ func defaultGnomeService() *ServiceGnome {
 		scheme: "gnome",
 		icon:   "dialog-information",
 		sender: defaultSender,
+		logger: NopLogger{},
 	}
 }
 
@@ -107,26 +146,69 @@ func (s *ServiceGnome) Init(route string, vars Vars, opts ...Option) error {
 	return nil
 }
 
-// Send sends the notification to the service.
-func (s *ServiceGnome) Send(title, body string) error {
-	// create payload
-	payload := gnomePayload{
-		appName: s.vars["name"],
-		icon:    s.icon,
-		title:   title,
-		body:    body,
+// Send sends the notification to the service unless ctx is already done.
+// The urgency of the notification is derived from the message priority.
+func (s *ServiceGnome) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
 	// send notification
-	err := s.sender(payload)
-	if err != nil {
-		log.Printf("error sending notification: %v", err.Error())
+	payload := s.payload(msg)
+	if err := s.sender(payload); err != nil {
+		return err
 	}
 
+	s.logger.Debug("notification sent", "scheme", s.scheme, "urgency", int(payload.urgency))
 	return nil
 }
 
+// payload returns the payload of the notification of the message.
+func (s *ServiceGnome) payload(msg Message) gnomePayload {
+	return gnomePayload{
+		appName: s.vars["name"],
+		icon:    s.icon,
+		title:   msg.Title,
+		body:    msg.Body,
+		urgency: urgencyOf(msg.Priority),
+		image:   msg.ImageURL,
+	}
+}
+
+// Preview returns the D-Bus call Send would make for the message. The
+// hints of the notification are returned as headers.
+func (s *ServiceGnome) Preview(msg Message) (Preview, error) {
+	n := newNotification(s.payload(msg))
+
+	hints := make(map[string]string, len(n.Hints))
+	for k, v := range n.Hints {
+		hints[k] = fmt.Sprint(v.Value())
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "app_name: %s\n", n.AppName)
+	fmt.Fprintf(&b, "app_icon: %s\n", n.AppIcon)
+	fmt.Fprintf(&b, "summary: %s\n", n.Summary)
+	fmt.Fprintf(&b, "body: %s\n", n.Body)
+	fmt.Fprintf(&b, "expire_timeout: %d\n", n.ExpireTimeout.Milliseconds())
+
+	return Preview{
+		Method:   "D-Bus",
+		Endpoint: "org.freedesktop.Notifications.Notify",
+		Headers:  hints,
+		Body:     b.String(),
+	}, nil
+}
+
 // SetOption sets the option for the service.
 func (s *ServiceGnome) SetOption(key string, value interface{}) {
-	s.vars[key] = value.(string)
+	switch key {
+	case "logger":
+		s.logger = value.(Logger)
+	default:
+		// the other options are meaningful to the gnome service as strings.
+		if v, ok := value.(string); ok {
+			s.vars[key] = v
+		}
+	}
 }
@@ -12,6 +12,8 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"log"
 	"testing"
 
@@ -79,8 +81,77 @@ func TestGnomeSend(t *testing.T) {
 		return nil
 	}
 
-	err := svc.Send("test", "message")
+	err := svc.Send(context.Background(), Message{Title: "test", Body: "message"})
 	if err != nil {
 		t.Errorf("expected no error, got %v", err)
 	}
 }
+
+// TestGnomeUrgency tests that the message priority sets the urgency of the
+// notification.
+func TestGnomeUrgency(t *testing.T) {
+	tests := []struct {
+		priority Priority
+		urgency  gnomeUrgency
+	}{
+		{PriorityLow, urgencyLow},
+		{PriorityNormal, urgencyNormal},
+		{PriorityHigh, urgencyCritical},
+		{PriorityUrgent, urgencyCritical},
+	}
+
+	for _, test := range tests {
+		svc := defaultGnomeService()
+		var got gnomePayload
+		svc.sender = func(payload gnomePayload) error {
+			got = payload
+			return nil
+		}
+
+		err := svc.Send(context.Background(), Message{Title: "test", Priority: test.priority})
+		if err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+		if got.urgency != test.urgency {
+			t.Errorf("expected urgency %d for %s, got %d", test.urgency, test.priority, got.urgency)
+		}
+	}
+}
+
+// TestGnomeSendError tests that the errors of the sender are returned.
+func TestGnomeSendError(t *testing.T) {
+	svc := defaultGnomeService()
+	svc.sender = func(payload gnomePayload) error {
+		return errors.New("no session bus")
+	}
+
+	err := svc.Send(context.Background(), Message{Title: "test", Body: "message"})
+	if err == nil || err.Error() != "no session bus" {
+		t.Errorf("expected the sender error, got %v", err)
+	}
+}
+
+// gnomeTestLogger is a Logger told apart from NopLogger.
+type gnomeTestLogger struct{ NopLogger }
+
+// TestGnomeSetOption tests that options are set by key, whatever the type
+// of their value.
+func TestGnomeSetOption(t *testing.T) {
+	svc := defaultGnomeService()
+	if err := svc.Init("gnome://", Vars{}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	svc.SetOption("name", "mio")
+	svc.SetOption("icon", gnomeTestLogger{})
+	if _, ok := svc.logger.(gnomeTestLogger); ok {
+		t.Errorf("expected a logger given as another option to be ignored")
+	}
+	svc.SetOption("logger", gnomeTestLogger{})
+	if _, ok := svc.logger.(gnomeTestLogger); !ok {
+		t.Errorf("expected the logger to be set, got %T", svc.logger)
+	}
+	if svc.vars["name"] != "mio" || svc.vars["icon"] != "" {
+		t.Errorf("expected only the name to be set, got %v", svc.vars)
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
 var jsonTemplates = []string{
@@ -43,6 +44,8 @@ type ServiceJSON struct {
 	isTLS    bool
 	rawRoute string
 	vars     Vars // this should probably be a Field struct
+	client   *http.Client
+	logger   Logger
 }
 
 func jsonSpec() Spec {
@@ -59,6 +62,8 @@ func defaultJSONService() *ServiceJSON {
 		method: "POST",
 		scheme: "json",
 		isTLS:  true,
+		client: &http.Client{Timeout: DefaultTimeout},
+		logger: NopLogger{},
 	}
 }
 
@@ -73,31 +78,81 @@ func (s *ServiceJSON) Init(route string, vars Vars, opts ...Option) error {
 	return nil
 }
 
-// Send sends a JSON message.
-func (s *ServiceJSON) Send(title, body string) error {
-	data, err := json.Marshal(map[string]string{
-		"title": title,
-		"body":  body,
-	})
+// jsonPayload is the body of the request sent by ServiceJSON.
+type jsonPayload struct {
+	Title     string            `json:"title"`
+	Body      string            `json:"body"`
+	Priority  string            `json:"priority"`
+	Severity  string            `json:"severity"`
+	Tags      []string          `json:"tags,omitempty"`
+	URL       string            `json:"url,omitempty"`
+	ImageURL  string            `json:"image_url,omitempty"`
+	Timestamp string            `json:"timestamp,omitempty"`
+	Extras    map[string]string `json:"extras,omitempty"`
+}
+
+func newJSONPayload(msg Message) jsonPayload {
+	payload := jsonPayload{
+		Title:    msg.Title,
+		Body:     msg.Body,
+		Priority: msg.Priority.String(),
+		Severity: msg.Severity.String(),
+		Tags:     msg.Tags,
+		URL:      msg.URL,
+		ImageURL: msg.ImageURL,
+		Extras:   msg.Extras,
+	}
+	if !msg.Timestamp.IsZero() {
+		payload.Timestamp = msg.Timestamp.Format(time.RFC3339)
+	}
+	return payload
+}
 
+// newRequest builds the request sending the message.
+func (s *ServiceJSON) newRequest(ctx context.Context, msg Message) (*http.Request, error) {
+	data, err := json.Marshal(newJSONPayload(msg))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// create a io.Reader from data
 	reader := bytes.NewReader(data)
 
-	client := http.DefaultClient
-	req, err := http.NewRequestWithContext(context.Background(),
+	req, err := http.NewRequestWithContext(ctx,
 		s.method, s.Endpoint(), reader)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
-	_, err = client.Do(req)
-	return err
+	return req, nil
+}
+
+// Send sends a JSON message, aborting the request once ctx is done.
+func (s *ServiceJSON) Send(ctx context.Context, msg Message) error {
+	req, err := s.newRequest(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	s.logger.Debug("request sent", "scheme", s.scheme, "status", resp.StatusCode)
+	return checkStatus(resp)
+}
+
+// Preview returns the request Send would make for the message.
+func (s *ServiceJSON) Preview(msg Message) (Preview, error) {
+	req, err := s.newRequest(context.Background(), msg)
+	if err != nil {
+		return Preview{}, err
+	}
+	return previewRequest(req)
 }
 
 // Endpoint returns the endpoint URL for the http request.
@@ -140,7 +195,13 @@ func (s *ServiceJSON) SetOption(key string, value interface{}) {
 		s.scheme = value.(string)
 	case "isTLS":
 		s.isTLS = value.(bool)
+	case "timeout":
+		s.client = &http.Client{Timeout: value.(time.Duration)}
+	case "logger":
+		s.logger = value.(Logger)
 	default:
-		s.vars[key] = value.(string)
+		if v, ok := value.(string); ok {
+			s.vars[key] = v
+		}
 	}
 }
@@ -1,12 +1,14 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/phea/mio/internal/matcher"
 )
@@ -122,8 +124,37 @@ func TestJSONSend(t *testing.T) {
 	route := "json://" + strings.TrimPrefix(ts.URL, "http://") + "/abc/def"
 	svc := defaultJSONService()
 	svc.Init(route, nil, SetTLS(false))
-	err := svc.Send("title", "message")
+	err := svc.Send(context.Background(), Message{Title: "title", Body: "message"})
 	if err != nil {
 		t.Errorf("expected no error, got %v", err)
 	}
 }
+
+// TestJSONPayload tests that the message fields are mapped to the payload.
+func TestJSONPayload(t *testing.T) {
+	ts := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	payload := newJSONPayload(Message{
+		Title:     "title",
+		Priority:  PriorityHigh,
+		Severity:  SeverityCritical,
+		Tags:      []string{"db", "oncall"},
+		URL:       "https://example.com/alert",
+		Timestamp: ts,
+	})
+
+	if payload.Priority != "high" {
+		t.Errorf("expected priority to be high, got %s", payload.Priority)
+	}
+	if payload.Severity != "critical" {
+		t.Errorf("expected severity to be critical, got %s", payload.Severity)
+	}
+	if len(payload.Tags) != 2 || payload.Tags[0] != "db" {
+		t.Errorf("expected tags to be [db oncall], got %v", payload.Tags)
+	}
+	if payload.URL != "https://example.com/alert" {
+		t.Errorf("expected url to be set, got %s", payload.URL)
+	}
+	if payload.Timestamp != "2023-01-02T03:04:05Z" {
+		t.Errorf("expected timestamp to be RFC3339, got %s", payload.Timestamp)
+	}
+}
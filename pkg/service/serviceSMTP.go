@@ -11,7 +11,16 @@
 
 package service
 
-import "net/url"
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
 
 var smtpTemplates = []string{
 	"smtp://",
@@ -20,6 +29,10 @@ var smtpTemplates = []string{
 	"smtp://{user}:{pass}@{host}:{port}",
 }
 
+// ErrSMTPNotImplemented is returned by the SMTP service, whose transport
+// is not implemented yet. It is permanent, see IsPermanent.
+var ErrSMTPNotImplemented = Permanent(fmt.Errorf("smtp transport not implemented"))
+
 var supportedSMTPProviders = []string{
 	"gmail.com",
 	"outlook.com",
@@ -40,6 +53,7 @@ type ServiceSMTP struct {
 	isTLS    bool
 	rawRoute string
 	vars     Vars
+	logger   Logger
 }
 
 func smtpSpec() Spec {
@@ -56,6 +70,7 @@ func defaultSMTPService() *ServiceSMTP {
 		scheme: "smtp",
 		port:   "587",
 		isTLS:  true,
+		logger: NopLogger{},
 	}
 }
 
@@ -65,6 +80,7 @@ func (s *ServiceSMTP) Init(route string, vars Vars, opts ...Option) error {
 		opt(s)
 	}
 	s.rawRoute = route
+	s.vars = vars
 	if vars["port"] != "" {
 		s.port = vars["port"]
 	}
@@ -72,9 +88,79 @@ func (s *ServiceSMTP) Init(route string, vars Vars, opts ...Option) error {
 	return nil
 }
 
-// Send sends the email
-func (s *ServiceSMTP) Send(title, body string) error {
-	return nil
+// Send fails with ErrSMTPNotImplemented unless ctx is already done.
+// NOTE: the SMTP transport is not implemented yet, use Preview to see the
+// email that would be sent.
+func (s *ServiceSMTP) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return ErrSMTPNotImplemented
+}
+
+// Preview returns the email Send would compose for the message, and the
+// mail server it is meant for.
+func (s *ServiceSMTP) Preview(msg Message) (Preview, error) {
+	return Preview{
+		Method:   "SMTP",
+		Endpoint: net.JoinHostPort(s.vars["host"], s.port),
+		Body:     string(s.compose(msg)),
+	}, nil
+}
+
+// xPriority maps the priority of a message to the X-Priority header,
+// where 1 is the highest and 5 the lowest.
+func xPriority(p Priority) string {
+	switch {
+	case p < PriorityNormal:
+		return "5 (Lowest)"
+	case p == PriorityHigh:
+		return "2 (High)"
+	case p > PriorityHigh:
+		return "1 (Highest)"
+	}
+	return "3 (Normal)"
+}
+
+// headerSanitizer removes the line breaks from header values, which would
+// otherwise let them inject headers or end the header section.
+var headerSanitizer = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// compose returns the MIME email for the message. The message URL is
+// appended to the body since plain text emails have no click-through.
+func (s *ServiceSMTP) compose(msg Message) []byte {
+	var b bytes.Buffer
+	header := func(key, value string) {
+		fmt.Fprintf(&b, "%s: %s\r\n", key, headerSanitizer.Replace(value))
+	}
+
+	if user, host := s.vars["user"], s.vars["host"]; user != "" && host != "" {
+		header("From", user+"@"+host)
+	}
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Title))
+
+	date := msg.Timestamp
+	if date.IsZero() {
+		date = time.Now()
+	}
+	header("Date", date.Format(time.RFC1123Z))
+	header("X-Priority", xPriority(msg.Priority))
+	header("X-Severity", msg.Severity.String())
+	if len(msg.Tags) > 0 {
+		header("Keywords", strings.Join(msg.Tags, ", "))
+	}
+	header("MIME-Version", "1.0")
+	header("Content-Type", `text/plain; charset="utf-8"`)
+	header("Content-Transfer-Encoding", "8bit")
+
+	b.WriteString("\r\n")
+	b.WriteString(msg.Body)
+	if msg.URL != "" {
+		b.WriteString("\r\n\r\n")
+		b.WriteString(msg.URL)
+	}
+	b.WriteString("\r\n")
+	return b.Bytes()
 }
 
 // SetOption sets options for the service.
@@ -82,5 +168,7 @@ func (s *ServiceSMTP) SetOption(key string, value interface{}) {
 	switch key {
 	case "isTLS":
 		s.isTLS = value.(bool)
+	case "logger":
+		s.logger = value.(Logger)
 	}
 }
@@ -17,7 +17,9 @@ import (
 	"encoding/xml"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
+	"time"
 )
 
 var xmlTemplates = []string{
@@ -42,6 +44,8 @@ type ServiceXML struct {
 	rawRoute string
 	isTLS    bool
 	vars     Vars // this should probably be a Field struct
+	client   *http.Client
+	logger   Logger
 }
 
 func xmlSpec() Spec {
@@ -58,6 +62,8 @@ func defaultXMLService() *ServiceXML {
 		scheme: "xml",
 		method: "POST",
 		isTLS:  true,
+		client: &http.Client{Timeout: DefaultTimeout},
+		logger: NopLogger{},
 	}
 }
 
@@ -72,31 +78,92 @@ func (s *ServiceXML) Init(route string, vars Vars, opts ...Option) error {
 }
 
 type xmlPayload struct {
-	Title   string
-	Body    string
-	XMLName xml.Name `xml:"Payload"`
+	Title     string
+	Body      string
+	Priority  string
+	Severity  string
+	Tags      []string   `xml:"Tags>Tag,omitempty"`
+	URL       string     `xml:",omitempty"`
+	ImageURL  string     `xml:",omitempty"`
+	Timestamp string     `xml:",omitempty"`
+	Extras    []xmlExtra `xml:"Extras>Extra,omitempty"`
+	XMLName   xml.Name   `xml:"Payload"`
 }
 
-// Send sends a XML message.
-func (s *ServiceXML) Send(title, body string) error {
-	payload := &xmlPayload{Title: title, Body: body}
-	data, err := xml.MarshalIndent(payload, "", "  ")
+type xmlExtra struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+func newXMLPayload(msg Message) *xmlPayload {
+	payload := &xmlPayload{
+		Title:    msg.Title,
+		Body:     msg.Body,
+		Priority: msg.Priority.String(),
+		Severity: msg.Severity.String(),
+		Tags:     msg.Tags,
+		URL:      msg.URL,
+		ImageURL: msg.ImageURL,
+	}
+	if !msg.Timestamp.IsZero() {
+		payload.Timestamp = msg.Timestamp.Format(time.RFC3339)
+	}
+
+	// sort the extras so the payload is deterministic.
+	keys := make([]string, 0, len(msg.Extras))
+	for k := range msg.Extras {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		payload.Extras = append(payload.Extras, xmlExtra{Key: k, Value: msg.Extras[k]})
+	}
+	return payload
+}
+
+// newRequest builds the request sending the message.
+func (s *ServiceXML) newRequest(ctx context.Context, msg Message) (*http.Request, error) {
+	data, err := xml.MarshalIndent(newXMLPayload(msg), "", "  ")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	reader := bytes.NewReader(data)
-	client := http.DefaultClient
-	req, err := http.NewRequestWithContext(context.Background(),
+	req, err := http.NewRequestWithContext(ctx,
 		s.method, s.Endpoint(), reader)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// set xml headers
 	req.Header.Set("Content-Type", "application/xml")
-	_, err = client.Do(req)
-	return err
+	return req, nil
+}
+
+// Send sends a XML message, aborting the request once ctx is done.
+func (s *ServiceXML) Send(ctx context.Context, msg Message) error {
+	req, err := s.newRequest(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	s.logger.Debug("request sent", "scheme", s.scheme, "status", resp.StatusCode)
+	return checkStatus(resp)
+}
+
+// Preview returns the request Send would make for the message.
+func (s *ServiceXML) Preview(msg Message) (Preview, error) {
+	req, err := s.newRequest(context.Background(), msg)
+	if err != nil {
+		return Preview{}, err
+	}
+	return previewRequest(req)
 }
 
 // Endpoint returns the endpoint URL for the http request.
@@ -139,7 +206,13 @@ func (s *ServiceXML) SetOption(key string, value interface{}) {
 		s.scheme = value.(string)
 	case "isTLS":
 		s.isTLS = value.(bool)
+	case "timeout":
+		s.client = &http.Client{Timeout: value.(time.Duration)}
+	case "logger":
+		s.logger = value.(Logger)
 	default:
-		s.vars[key] = value.(string)
+		if v, ok := value.(string); ok {
+			s.vars[key] = v
+		}
 	}
 }
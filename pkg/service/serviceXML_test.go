@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"encoding/xml"
 	"net/http"
 	"net/http/httptest"
@@ -115,7 +116,7 @@ func TestXMLSend(t *testing.T) {
 	route := "xml://" + strings.TrimPrefix(ts.URL, "http://") + "/abc/def"
 	svc := defaultXMLService()
 	svc.Init(route, nil, SetTLS(false))
-	err := svc.Send("test", "message")
+	err := svc.Send(context.Background(), Message{Title: "test", Body: "message"})
 	if err != nil {
 		t.Errorf("expected no error, got %v", err)
 	}
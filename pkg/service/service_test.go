@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// TestIsTemporary tests the classification of temporary errors.
+func TestIsTemporary(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("boom"), false},
+		{Temporary(errors.New("boom")), true},
+		{fmt.Errorf("wrapped: %w", Temporary(errors.New("boom"))), true},
+		{&StatusError{Code: 502, Status: "502 Bad Gateway"}, true},
+		{&StatusError{Code: 429, Status: "429 Too Many Requests"}, true},
+		{&StatusError{Code: 404, Status: "404 Not Found"}, false},
+	}
+
+	for _, test := range tests {
+		if got := IsTemporary(test.err); got != test.want {
+			t.Errorf("expected IsTemporary(%v) to be %t", test.err, test.want)
+		}
+	}
+}
+
+// TestIsPermanent tests the classification of permanent errors.
+func TestIsPermanent(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("boom"), false},
+		{Permanent(errors.New("boom")), true},
+		{fmt.Errorf("wrapped: %w", Permanent(errors.New("boom"))), true},
+		{&StatusError{Code: 404, Status: "404 Not Found"}, true},
+		{&StatusError{Code: 429, Status: "429 Too Many Requests"}, false},
+		{&StatusError{Code: 408, Status: "408 Request Timeout"}, false},
+		{&StatusError{Code: 502, Status: "502 Bad Gateway"}, false},
+	}
+
+	for _, test := range tests {
+		if got := IsPermanent(test.err); got != test.want {
+			t.Errorf("expected IsPermanent(%v) to be %t", test.err, test.want)
+		}
+	}
+}
+
+// legacyService implements the title and body contract.
+type legacyService struct {
+	title, body string
+	isTLS       bool
+}
+
+func (s *legacyService) Init(route string, vars Vars, opts ...Option) error { return nil }
+func (s *legacyService) Send(title, body string) error {
+	s.title, s.body = title, body
+	return nil
+}
+func (s *legacyService) SetOption(key string, value interface{}) {
+	if key == "isTLS" {
+		s.isTLS = value.(bool)
+	}
+}
+
+// TestAdapt tests that legacy services get the title, body and options.
+func TestAdapt(t *testing.T) {
+	legacy := &legacyService{}
+	svc := Adapt(legacy)
+	if err := svc.Init("legacy://", nil, SetTLS(true)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !legacy.isTLS {
+		t.Errorf("expected the option to reach the legacy service")
+	}
+
+	err := svc.Send(context.Background(), Message{Title: "title", Body: "body"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if legacy.title != "title" || legacy.body != "body" {
+		t.Errorf("expected title and body to be sent, got %q %q", legacy.title, legacy.body)
+	}
+}